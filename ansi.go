@@ -0,0 +1,120 @@
+// +build !windows
+
+package gexpect
+
+import "bytes"
+
+// Stripper states.
+const (
+	stripText = iota
+	stripEscape
+	stripIntermediate
+	stripCSI
+	stripString
+	stripStringEscape
+)
+
+// ansiStripper removes escape sequences from a stream of terminal output,
+// keeping its state between calls so that a sequence may be split across
+// reads.
+type ansiStripper struct {
+	crlf  bool // turn "\r\n" into "\n"
+	state int
+	cr    bool // a "\r" is held back until the next byte is known
+	// lateCR is set when a read ended with a "\r", which was let through so
+	// that it can be matched at once; it is taken back from the buffer if
+	// the next read starts with "\n" and it is still unread.
+	lateCR bool
+	out    []byte
+}
+
+// write appends p to dst without escape sequences.
+func (s *ansiStripper) write(dst *bytes.Buffer, p []byte) {
+	if s.lateCR && len(p) > 0 {
+		s.lateCR = false
+		if p[0] == '\n' && s.state == stripText {
+			if n := dst.Len(); n > 0 && dst.Bytes()[n-1] == '\r' {
+				dst.Truncate(n - 1)
+			}
+		}
+	}
+	s.out = s.filter(s.out[:0], p)
+	if s.cr {
+		s.cr = false
+		s.lateCR = true
+		s.out = append(s.out, '\r')
+	}
+	dst.Write(s.out)
+}
+
+// filter returns p without escape sequences, appended to out.
+func (s *ansiStripper) filter(out, p []byte) []byte {
+	for _, b := range p {
+		switch s.state {
+		case stripText:
+			if s.cr {
+				s.cr = false
+				if b != '\n' {
+					out = append(out, '\r')
+				}
+			}
+			switch {
+			case b == 0x1b:
+				s.state = stripEscape
+			case b == '\r' && s.crlf:
+				s.cr = true
+			default:
+				out = append(out, b)
+			}
+		case stripEscape:
+			switch {
+			case b == '[':
+				s.state = stripCSI
+			case b == ']' || b == 'P' || b == 'X' || b == '^' || b == '_':
+				// OSC, DCS, SOS, PM and APC run until BEL or ST.
+				s.state = stripString
+			case b >= 0x20 && b < 0x30:
+				s.state = stripIntermediate
+			default:
+				s.state = stripText
+			}
+		case stripIntermediate:
+			if b < 0x20 || b >= 0x30 {
+				s.state = stripText
+			}
+		case stripCSI:
+			if b >= 0x40 && b < 0x7f {
+				s.state = stripText
+			}
+		case stripString:
+			switch b {
+			case 0x07:
+				s.state = stripText
+			case 0x1b:
+				s.state = stripStringEscape
+			}
+		case stripStringEscape:
+			if b == '\\' {
+				s.state = stripText
+			} else {
+				s.state = stripString
+			}
+		}
+	}
+	return out
+}
+
+// StripANSI removes terminal escape sequences (colours and other SGR
+// attributes, cursor movement, window titles) from the output seen by the
+// Expect methods, ReadLine, ReadUntil and Capture. If crlf is true, "\r\n" is
+// also turned into "\n", unless the "\r" ended one read of the output and was
+// consumed before the "\n" arrived. The Screen and loggers still get the raw
+// output.
+//
+// Only output read after the call is filtered, so call it before the first
+// Expect.
+func (expect *ExpectSubprocess) StripANSI(crlf bool) {
+	expect.buf.mu.Lock()
+	defer expect.buf.mu.Unlock()
+	expect.buf.strip = &ansiStripper{crlf: crlf}
+}
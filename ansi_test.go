@@ -0,0 +1,66 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestANSIStripper(t *testing.T) {
+	t.Logf("Testing the ANSI stripper...")
+	input := "\x1b[1;31m3\x1b[0m errors\r\n\x1b]0;title\x07\x1b(Bdone\x1b[?25l\r\x1bP1$r\x1b\\!\r\n"
+	for _, crlf := range []bool{false, true} {
+		want := "3 errors\r\ndone\r!\r\n"
+		if crlf {
+			want = "3 errors\ndone\r!\n"
+		}
+		// Feed the input a byte at a time so that every sequence is split.
+		s := &ansiStripper{crlf: crlf}
+		var out bytes.Buffer
+		for i := 0; i < len(input); i++ {
+			s.write(&out, []byte{input[i]})
+		}
+		if out.String() != want {
+			t.Fatalf("crlf=%v: expected %q, got %q", crlf, want, out)
+		}
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	t.Logf("Testing StripANSI...")
+	child, err := Spawn(`printf '\033[1;31m12\033[0m errors\n\033[32mok\033[m\n'`, WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	child.StripANSI(true)
+
+	match, err := child.ExpectRegexFind(`(\d+) errors`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if match[1] != "12" {
+		t.Fatalf("Expected 12 errors, got %q", match)
+	}
+	if line, err := child.ReadLine(); err != nil || line != "" {
+		t.Fatalf("Expected the rest of the first line to be empty, got %q, %v", line, err)
+	}
+	if line, err := child.ReadLine(); err != nil || line != "ok" {
+		t.Fatalf("Expected ok, got %q, %v", line, err)
+	}
+}
+
+func TestStripANSITrailingCR(t *testing.T) {
+	t.Logf("Testing StripANSI with output ending in a carriage return...")
+	child, err := Spawn(`sh -c 'printf "prompt\r"; sleep 5'`, WithTimeout(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	child.StripANSI(true)
+	if err := child.Expect("prompt\r"); err != nil {
+		t.Fatal(err)
+	}
+}
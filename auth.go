@@ -0,0 +1,129 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"context"
+	"errors"
+	"regexp"
+)
+
+var (
+	// ErrAuthFailed is returned when the credentials were refused.
+	ErrAuthFailed = errors.New("gexpect: authentication failed")
+	// ErrHostKeyChanged is returned when the host key of an SSH server is not
+	// the one recorded for it, which may mean someone is impersonating it.
+	ErrHostKeyChanged = errors.New("gexpect: host key has changed")
+	// ErrHostKeyUnknown is returned when ssh asks to confirm the host key of
+	// a server it has not seen before and the Authenticator may not accept
+	// it.
+	ErrHostKeyUnknown = errors.New("gexpect: host key is unknown")
+)
+
+// authError is an error of the program or library that refused to log in,
+// which also matches one of the Err values above with errors.Is.
+type authError struct {
+	kind error
+	err  error
+}
+
+func (e *authError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *authError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+// The prompts and messages of ssh, su, sudo and login that an Authenticator
+// answers.
+var (
+	hostKeyConfirmRe = regexp.MustCompile(`Are you sure you want to continue connecting \([^)]*\)\? *`)
+	hostKeyChangedRe = regexp.MustCompile(`REMOTE HOST IDENTIFICATION HAS CHANGED|Host key verification failed`)
+	passwordRe       = regexp.MustCompile(`(?i)(password|passphrase)[^\n:]*: *\z`)
+	authFailedRe     = regexp.MustCompile(`(?i)permission denied|sorry, try again|authentication failure|login incorrect|incorrect password`)
+	defaultPromptRe  = regexp.MustCompile(`[$#%>] *\z`)
+)
+
+// Authenticator logs in through the prompts of ssh, su, sudo, login and the
+// like: it confirms host keys, answers password prompts, and recognises
+// refusals, until the prompt of the logged in session appears.
+type Authenticator struct {
+	// Passwords are given in turn, one to each password or passphrase
+	// prompt. A prompt after the last one fails with ErrAuthFailed.
+	Passwords []string
+	// AcceptNewHostKey answers yes when ssh asks to confirm the key of a
+	// server it does not know. Otherwise Login answers no and fails with
+	// ErrHostKeyUnknown.
+	AcceptNewHostKey bool
+	// Prompt matches the prompt shown once logged in. The default matches a
+	// shell prompt ending in $, #, % or > with nothing printed after it.
+	Prompt *regexp.Regexp
+}
+
+// Login answers the prompts of the session until it shows Prompt. It fails
+// with ErrAuthFailed, ErrHostKeyChanged or ErrHostKeyUnknown, matching with
+// errors.Is, when the login is refused, or with the error of the last wait
+// if the session ends or times out first. The session timeout applies to
+// each prompt.
+func (a *Authenticator) Login(expect *ExpectSubprocess) error {
+	return a.LoginContext(context.Background(), expect)
+}
+
+// LoginContext is Login bounded by ctx.
+func (a *Authenticator) LoginContext(ctx context.Context, expect *ExpectSubprocess) error {
+	prompt := a.Prompt
+	if prompt == nil {
+		prompt = defaultPromptRe
+	}
+	passwords := a.Passwords
+	var refused error
+	fail := func(kind error, match []string) error {
+		return &authError{kind: kind, err: errors.New(match[0])}
+	}
+	return expect.ExpectSwitchContext(ctx,
+		Case{Regexp(hostKeyChangedRe), func(match []string) error {
+			return fail(ErrHostKeyChanged, match)
+		}},
+		Case{Regexp(hostKeyConfirmRe), func(match []string) error {
+			if !a.AcceptNewHostKey {
+				expect.SendLine("no")
+				return fail(ErrHostKeyUnknown, match)
+			}
+			if err := expect.SendLine("yes"); err != nil {
+				return err
+			}
+			return Continue
+		}},
+		Case{Regexp(authFailedRe), func(match []string) error {
+			// Some programs ask again, others give up.
+			refused = fail(ErrAuthFailed, match)
+			return Continue
+		}},
+		Case{Regexp(passwordRe), func(match []string) error {
+			if len(passwords) == 0 {
+				if refused != nil {
+					return refused
+				}
+				return fail(ErrAuthFailed, match)
+			}
+			err := expect.SendPasswordLine(passwords[0])
+			passwords = passwords[1:]
+			if err != nil {
+				return err
+			}
+			return Continue
+		}},
+		Case{Regexp(prompt), nil},
+		Case{EOF, func([]string) error {
+			if refused != nil {
+				return refused
+			}
+			return expect.readError("Login", prompt.String(), expect.buf.error())
+		}},
+	)
+}
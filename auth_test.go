@@ -0,0 +1,64 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+const sudoScript = `n=0
+while :; do
+	printf '[sudo] password for joe: '; read p
+	[ "$p" = good ] && break
+	echo 'Sorry, try again.'
+	n=$((n+1))
+	[ $n = 3 ] && { echo 'sudo: 3 incorrect password attempts'; exit 1; }
+done
+printf '# '; read x`
+
+const sshScript = `echo "The authenticity of host 'h (10.0.0.1)' can't be established."
+printf 'Are you sure you want to continue connecting (yes/no/[fingerprint])? '; read a
+[ "$a" = yes ] || exit 3
+printf "joe@h's password: "; read p
+[ "$p" = good ] || { echo 'Permission denied (publickey,password).'; exit 255; }
+printf '$ '; read x`
+
+const changedScript = `echo '@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@'
+echo '@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @'
+echo '@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@'
+echo 'Host key verification failed.'
+exit 255`
+
+func TestAuthenticator(t *testing.T) {
+	t.Logf("Testing Authenticator...")
+	tests := []struct {
+		name   string
+		script string
+		auth   Authenticator
+		err    error
+	}{
+		{"ssh", sshScript, Authenticator{Passwords: []string{"good"}, AcceptNewHostKey: true}, nil},
+		{"ssh wrong password", sshScript, Authenticator{Passwords: []string{"bad"}, AcceptNewHostKey: true}, ErrAuthFailed},
+		{"ssh unknown host", sshScript, Authenticator{Passwords: []string{"good"}}, ErrHostKeyUnknown},
+		{"ssh changed host", changedScript, Authenticator{Passwords: []string{"good"}}, ErrHostKeyChanged},
+		{"sudo", sudoScript, Authenticator{Passwords: []string{"good"}}, nil},
+		{"sudo retry", sudoScript, Authenticator{Passwords: []string{"bad", "good"}}, nil},
+		{"sudo wrong password", sudoScript, Authenticator{Passwords: []string{"bad"}}, ErrAuthFailed},
+		{"sudo no password", sudoScript, Authenticator{}, ErrAuthFailed},
+	}
+	for _, test := range tests {
+		child, err := SpawnArgs("sh", "-c", test.script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		child.SetTimeout(5 * time.Second)
+		child.SetLineTerminator("\n")
+		err = test.auth.Login(child)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+		child.Close()
+	}
+}
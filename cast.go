@@ -0,0 +1,203 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castHeader is the first line of an asciicast v2 file.
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// castRecorder writes the traffic with the child as asciicast v2 events:
+// output as "o" and input as "i", timed from the start of the recording.
+type castRecorder struct {
+	w       io.Writer
+	start   time.Time
+	partial map[string][]byte // an incomplete UTF-8 sequence per direction
+	err     error
+}
+
+func (r *castRecorder) log(t time.Time, dir string, data []byte, pattern string) {
+	if r.err != nil {
+		return
+	}
+	// Events hold strings, so a character split across two reads is kept
+	// back until it is complete.
+	data = append(r.partial[dir], data...)
+	end := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	r.partial[dir] = append([]byte(nil), data[end:]...)
+	if end == 0 {
+		return
+	}
+	code := "o"
+	if dir == dirSend {
+		code = "i"
+	}
+	elapsed := math.Round(t.Sub(r.start).Seconds()*1e6) / 1e6
+	line, err := json.Marshal([]interface{}{elapsed, code, string(data[:end])})
+	if err == nil {
+		_, err = r.w.Write(append(line, '\n'))
+	}
+	r.err = err
+}
+
+// Record writes the session from now on to w in asciinema's asciicast v2
+// format, with the output of the child and what is sent to it as input
+// events. The recording can be played with asciinema, or with Replay.
+func (expect *ExpectSubprocess) Record(w io.Writer) error {
+	header := castHeader{Version: 2, Width: 80, Height: 24}
+	if rows, cols, err := expect.GetWinSize(); err == nil {
+		header.Width, header.Height = int(cols), int(rows)
+	} else if expect.screen != nil {
+		header.Height, header.Width = expect.screen.Size()
+	}
+	start := time.Now()
+	header.Timestamp = start.Unix()
+	if term := expect.term(); term != "" {
+		header.Env = map[string]string{"TERM": term}
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	expect.log.add(&castRecorder{w: w, start: start, partial: map[string][]byte{}})
+	return nil
+}
+
+// term returns the value of TERM in the child's environment, or the
+// terminal type given to a remote session.
+func (expect *ExpectSubprocess) term() string {
+	if expect.termName != "" {
+		return expect.termName
+	}
+	env := os.Environ()
+	if expect.Cmd != nil && expect.Cmd.Env != nil {
+		env = expect.Cmd.Env
+	}
+	term := ""
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "TERM=") {
+			term = kv[len("TERM="):]
+		}
+	}
+	return term
+}
+
+// castPlayer plays back the output events of a recording as the output of a
+// session. Input is discarded.
+type castPlayer struct {
+	dec     *json.Decoder
+	speed   float64
+	start   time.Time
+	pending []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (p *castPlayer) Read(b []byte) (int, error) {
+	for len(p.pending) == 0 {
+		var event []interface{}
+		if err := p.dec.Decode(&event); err != nil {
+			if err == io.EOF {
+				return 0, io.EOF
+			}
+			return 0, fmt.Errorf("gexpect: bad asciicast event: %w", err)
+		}
+		if len(event) != 3 {
+			return 0, fmt.Errorf("gexpect: bad asciicast event %v", event)
+		}
+		at, _ := event[0].(float64)
+		code, _ := event[1].(string)
+		data, _ := event[2].(string)
+		if code != "o" {
+			continue
+		}
+		if p.speed > 0 {
+			delay := time.Until(p.start.Add(time.Duration(at / p.speed * float64(time.Second))))
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-p.closed:
+				timer.Stop()
+				return 0, os.ErrClosed
+			}
+		}
+		p.pending = []byte(data)
+	}
+	select {
+	case <-p.closed:
+		return 0, os.ErrClosed
+	default:
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *castPlayer) Write(b []byte) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, os.ErrClosed
+	default:
+		return len(b), nil
+	}
+}
+
+func (p *castPlayer) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+// Replay returns a session whose output is that of the asciicast v2 recording
+// read from r, so that an Expect script can be run again against a recorded
+// session. speed scales the recorded timing: 1 replays in real time, 2 twice
+// as fast, and 0 as fast as possible. Input sent to the session is discarded.
+// The session has a Screen the size given in the recording.
+func Replay(r io.Reader, speed float64) (*ExpectSubprocess, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	var header castHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("gexpect: bad asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, errors.New("gexpect: only asciicast version 2 is supported")
+	}
+	if header.Width <= 0 || header.Height <= 0 {
+		header.Width, header.Height = 80, 24
+	}
+	expect := newSession(&castPlayer{dec: dec, speed: speed, start: time.Now(), closed: make(chan struct{})})
+	expect.screen = NewScreen(header.Height, header.Width)
+	expect.buf.screen = expect.screen
+	return expect, nil
+}
@@ -0,0 +1,106 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	t.Logf("Testing Record...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", "read line; echo got $line"},
+		Rows:    30,
+		Cols:    100,
+		Term:    "xterm",
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cast syncBuffer
+	if err = child.Record(&cast); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Send("héllo\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("got héllo"); err != nil {
+		t.Fatal(err)
+	}
+	child.Close()
+
+	lines := strings.Split(strings.TrimSpace(cast.String()), "\n")
+	var header castHeader
+	if err = json.Unmarshal([]byte(lines[0]), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header.Version != 2 || header.Width != 100 || header.Height != 30 || header.Env["TERM"] != "xterm" {
+		t.Fatalf("Unexpected header %s", lines[0])
+	}
+	var event []interface{}
+	if err = json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event[1] != "i" || event[2] != "héllo\n" {
+		t.Fatalf("Expected the input event first, got %s", lines[1])
+	}
+	var output string
+	for _, line := range lines[2:] {
+		if err = json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event[1] != "o" {
+			t.Fatalf("Expected an output event, got %s", line)
+		}
+		output += event[2].(string)
+	}
+	if !strings.Contains(output, "got héllo") {
+		t.Fatalf("Expected the output to be recorded, got %q", output)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	t.Logf("Testing Replay...")
+	cast := `{"version": 2, "width": 40, "height": 10}
+[0.01, "o", "login: "]
+[0.5, "i", "root\r"]
+[0.6, "o", "root\r\n\u001b[1mWelcome\u001b[0m\r\n# "]
+`
+	child, err := Replay(strings.NewReader(cast), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	child.SetTimeout(5 * time.Second)
+
+	start := time.Now()
+	if err = child.Expect("login: "); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SendLine("root"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("# "); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("Expected the recorded delays to be kept, took %v", elapsed)
+	}
+	if !child.Screen().Contains("Welcome") {
+		t.Fatalf("Expected the screen to show Welcome, got %q", child.Screen().Text())
+	}
+	if err = child.Expect("more"); !errors.Is(err, ErrEOF) {
+		t.Fatalf("Expected the output to end, got %v", err)
+	}
+	if err = child.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = child.GetWinSize(); err != ErrNoPty {
+		t.Fatalf("Expected ErrNoPty, got %v", err)
+	}
+}
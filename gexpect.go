@@ -4,14 +4,22 @@ package gexpect
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
 	"regexp"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unicode/utf8"
+	"unsafe"
 
 	shell "github.com/kballard/go-shellquote"
 	"github.com/kr/pty"
@@ -19,118 +27,402 @@ import (
 
 var (
 	ErrEmptySearch = errors.New("empty search string")
+	// ErrTimeout matches every *TimeoutError with errors.Is.
+	ErrTimeout = errors.New("gexpect: timed out")
+	// ErrEOF matches every *EOFError with errors.Is.
+	ErrEOF = errors.New("gexpect: end of output")
+	// ErrNoPty is returned by the methods that control the terminal of a
+	// session that has none, such as one replayed from a recording.
+	ErrNoPty = errors.New("gexpect: session has no pty")
 )
 
+// eofGrace is how long an EOFError waits for the child to exit so it can
+// report the exit status.
+const eofGrace = 100 * time.Millisecond
+
+// TimeoutError is returned when a call runs out of time before its pattern
+// appears, whether its own timeout ran out or the deadline of its context
+// passed; Timeout is zero in the latter case. Output holds what the child
+// printed that is still unread.
+type TimeoutError struct {
+	Pattern string
+	Timeout time.Duration
+	Output  []byte
+
+	call string
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout <= 0 {
+		return fmt.Sprintf("%s reached its deadline waiting for '%v'.\nOutput:\n%s", e.call, e.Pattern, e.Output)
+	}
+	return fmt.Sprintf("%s timed out after %v waiting for '%v'.\nOutput:\n%s", e.call, e.Timeout, e.Pattern, e.Output)
+}
+
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// EOFError is returned when the child's output ends before the pattern
+// appears. ExitStatus is -1 if the child had not exited by then, or was
+// killed by a signal. Err is the underlying read error.
+type EOFError struct {
+	Pattern    string
+	ExitStatus int
+	Output     []byte
+	Err        error
+
+	call string
+}
+
+func (e *EOFError) Error() string {
+	return fmt.Sprintf("%s reached the end of output (exit status %d) waiting for '%v'.\nOutput:\n%s", e.call, e.ExitStatus, e.Pattern, e.Output)
+}
+
+// Is reports true for ErrEOF and, for callers comparing with the standard
+// library's marker, io.EOF.
+func (e *EOFError) Is(target error) bool {
+	return target == ErrEOF || target == io.EOF
+}
+
+func (e *EOFError) Unwrap() error {
+	return e.Err
+}
+
+// NoMatchError is returned by ExpectRegexFind when the output ended without
+// matching the regular expression. Err is the *EOFError that ended it.
+type NoMatchError struct {
+	Pattern string
+	Output  []byte
+	Err     error
+}
+
+func (e *NoMatchError) Error() string {
+	return fmt.Sprintf("ExpectRegex didn't find regex '%v'.", e.Pattern)
+}
+
+func (e *NoMatchError) Unwrap() error {
+	return e.Err
+}
+
+// Option changes how long a call may block. Passed to Spawn, Command or
+// SpawnAtDirectory it sets the default for the session; passed to Expect,
+// ExpectRegex, ExpectRegexFind, ReadLine or ReadUntil it overrides that
+// default for one call.
+type Option func(*options)
+
+type options struct {
+	timeout time.Duration
+}
+
+// WithTimeout limits a call to d. A d of zero or less means no limit.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// NoTimeout lets a call block for as long as it takes, whatever the session
+// default, e.g. while a person is typing into an interactive program.
+func NoTimeout() Option {
+	return WithTimeout(0)
+}
+
 type ExpectSubprocess struct {
-	Cmd          *exec.Cmd
-	buf          *buffer
-	outputBuffer []byte
+	Cmd   *exec.Cmd
+	buf   *buffer
+	pty   *os.File // the pty master, nil if the session has no terminal
+	pipes bool
+	// termName is the terminal type of a remote session.
+	termName string
+	stdout   *ExpectSubprocess
+	stderr   *ExpectSubprocess
+	timeout  atomic.Int64
+	size     *pty.Winsize
+	screen   *Screen
+	log      transcript
+
+	reapOnce sync.Once
+	exited   chan struct{}
+	waitErr  error
+
+	sendDelay  atomic.Int64
+	sendJitter atomic.Int64
+
+	mu         sync.Mutex
+	before     string
+	match      []string
+	terminator string // empty for the default
 }
 
-type buffer struct {
-	f       *os.File
-	b       bytes.Buffer
-	collect bool
+// Before returns the output that preceded the last successful match of any
+// Expect method.
+func (expect *ExpectSubprocess) Before() string {
+	expect.mu.Lock()
+	defer expect.mu.Unlock()
+	return expect.before
+}
 
-	collection bytes.Buffer
+// After returns the text of the last successful match.
+func (expect *ExpectSubprocess) After() string {
+	expect.mu.Lock()
+	defer expect.mu.Unlock()
+	if len(expect.match) == 0 {
+		return ""
+	}
+	return expect.match[0]
 }
 
-func (buf *buffer) StartCollecting() {
-	buf.collect = true
+// Match returns the last successful match followed by its regexp submatches,
+// if any.
+func (expect *ExpectSubprocess) Match() []string {
+	expect.mu.Lock()
+	defer expect.mu.Unlock()
+	return append([]string(nil), expect.match...)
 }
 
-func (buf *buffer) StopCollecting() (result string) {
-	result = string(buf.collection.Bytes())
-	buf.collect = false
-	buf.collection.Reset()
-	return result
+// setMatch records a match, masked as by SendSecret and Redact.
+func (expect *ExpectSubprocess) setMatch(before string, match []string) {
+	before = expect.log.redactString(before)
+	masked := make([]string, len(match))
+	for i, m := range match {
+		masked[i] = expect.log.redactString(m)
+	}
+	expect.mu.Lock()
+	defer expect.mu.Unlock()
+	expect.before = before
+	expect.match = masked
 }
 
-func (buf *buffer) Read(chunk []byte) (int, error) {
-	nread := 0
-	if buf.b.Len() > 0 {
-		n, err := buf.b.Read(chunk)
+// buffer holds the output of the child. A single goroutine, started on first
+// use, copies everything read from f into b; readers inspect b under mu and
+// only remove what they have matched, so a read that times out or is
+// cancelled never loses output.
+type buffer struct {
+	f      io.ReadWriteCloser
+	b      bytes.Buffer
+	screen *Screen // if set, also receives everything read from f
+	strip  *ansiStripper
+	log    *transcript
+
+	mu       sync.Mutex
+	err      error         // error the reader goroutine stopped with
+	notify   chan struct{} // closed the next time b or err change
+	captured []byte        // consumed output since Capture, nil when not capturing
+	start    sync.Once
+}
+
+func (buf *buffer) pump() {
+	chunk := make([]byte, 4096)
+	for {
+		n, err := buf.f.Read(chunk)
+		if buf.log != nil {
+			buf.log.record(dirRecv, chunk[:n])
+			if err != nil {
+				buf.log.flush()
+			}
+		}
+		if buf.screen != nil {
+			buf.screen.Write(chunk[:n])
+		}
+		buf.mu.Lock()
+		if buf.strip != nil {
+			buf.strip.write(&buf.b, chunk[:n])
+		} else {
+			buf.b.Write(chunk[:n])
+		}
 		if err != nil {
-			return n, err
+			buf.err = err
+		}
+		if buf.notify != nil {
+			close(buf.notify)
+			buf.notify = nil
 		}
-		if n == len(chunk) {
-			return n, nil
+		buf.mu.Unlock()
+		if err != nil {
+			return
 		}
-		nread = n
 	}
-	fn, err := buf.f.Read(chunk[nread:])
-	return fn + nread, err
 }
 
-func (buf *buffer) ReadRune() (r rune, size int, err error) {
-	l := buf.b.Len()
+// run starts the reader goroutine if it is not running yet.
+func (buf *buffer) run() {
+	buf.start.Do(func() {
+		go buf.pump()
+	})
+}
 
-	chunk := make([]byte, utf8.UTFMax)
-	if l > 0 {
-		n, err := buf.b.Read(chunk)
-		if err != nil {
-			return 0, 0, err
-		}
-		if utf8.FullRune(chunk[:n]) {
-			r, rL := utf8.DecodeRune(chunk)
-			if n > rL {
-				buf.PutBack(chunk[rL:n])
-			}
-			if buf.collect {
-				buf.collection.WriteRune(r)
+// wait calls scan with the unread output, and again every time more of it
+// arrives, until scan reports done; the first n bytes are then consumed.
+// atEOF tells scan that no more output will follow. If scan never finishes,
+// wait returns the error that ended the output, or that of ctx.
+func (buf *buffer) wait(ctx context.Context, scan func(data []byte, atEOF bool) (n int, done bool)) error {
+	buf.run()
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	for {
+		if n, done := scan(buf.b.Bytes(), buf.err != nil); done {
+			if buf.captured != nil {
+				buf.captured = append(buf.captured, buf.b.Next(n)...)
+			} else {
+				buf.b.Next(n)
 			}
-			return r, rL, nil
+			return nil
+		}
+		if buf.err != nil {
+			return buf.err
+		}
+		if buf.notify == nil {
+			buf.notify = make(chan struct{})
+		}
+		notify := buf.notify
+		buf.mu.Unlock()
+		select {
+		case <-notify:
+			buf.mu.Lock()
+		case <-ctx.Done():
+			buf.mu.Lock()
+			return ctx.Err()
 		}
 	}
-	// else add bytes from the file, then try that
-	for l < utf8.UTFMax {
-		fn, err := buf.f.Read(chunk[l : l+1])
-		if err != nil {
-			return 0, 0, err
+}
+
+func (buf *buffer) Read(chunk []byte) (n int, err error) {
+	err = buf.wait(context.Background(), func(data []byte, atEOF bool) (int, bool) {
+		n = copy(chunk, data)
+		return n, n > 0
+	})
+	return n, err
+}
+
+func (buf *buffer) ReadRune() (r rune, size int, err error) {
+	err = buf.wait(context.Background(), func(data []byte, atEOF bool) (int, bool) {
+		if !utf8.FullRune(data) && !(atEOF && len(data) > 0) {
+			return 0, false
 		}
-		l = l + fn
+		r, size = utf8.DecodeRune(data)
+		return size, true
+	})
+	return r, size, err
+}
 
-		if utf8.FullRune(chunk[:l]) {
-			r, rL := utf8.DecodeRune(chunk)
-			if buf.collect {
-				buf.collection.WriteRune(r)
-			}
-			return r, rL, nil
+// runeReader feeds the unread output to the regexp package without consuming
+// it, so that only the part that matched needs to be taken from the buffer.
+type runeReader struct {
+	ctx context.Context
+	buf *buffer
+	pos int
+}
+
+func (rr *runeReader) ReadRune() (r rune, size int, err error) {
+	err = rr.buf.wait(rr.ctx, func(data []byte, atEOF bool) (int, bool) {
+		data = data[rr.pos:]
+		if !utf8.FullRune(data) && !(atEOF && len(data) > 0) {
+			return 0, false
 		}
-	}
-	return 0, 0, errors.New("File is not a valid UTF=8 encoding")
+		r, size = utf8.DecodeRune(data)
+		rr.pos += size
+		return 0, true
+	})
+	return r, size, err
 }
 
-func (buf *buffer) PutBack(chunk []byte) {
-	if len(chunk) == 0 {
-		return
-	}
-	if buf.b.Len() == 0 {
-		buf.b.Write(chunk)
-		return
+// peek returns a copy of the first n bytes of unread output, or all of it if
+// n is negative.
+func (buf *buffer) peek(n int) []byte {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	data := buf.b.Bytes()
+	if n >= 0 {
+		data = data[:n]
 	}
-	d := make([]byte, 0, len(chunk)+buf.b.Len())
-	d = append(d, chunk...)
-	d = append(d, buf.b.Bytes()...)
-	buf.b.Reset()
-	buf.b.Write(d)
+	return append([]byte(nil), data...)
+}
+
+// error returns the error the reader goroutine stopped with, if it has.
+func (buf *buffer) error() error {
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	return buf.err
 }
 
-func SpawnAtDirectory(command string, directory string) (*ExpectSubprocess, error) {
-	expect, err := _spawn(command)
+// discard consumes the first n bytes of unread output.
+func (buf *buffer) discard(n int) {
+	buf.wait(context.Background(), func(data []byte, atEOF bool) (int, bool) {
+		return n, true
+	})
+}
+
+// EnvMode says how SpawnOptions.Env is combined with the environment of the
+// current process.
+type EnvMode int
+
+const (
+	// EnvMerge gives the child the current environment, with the entries of
+	// Env added or replacing variables of the same name.
+	EnvMerge EnvMode = iota
+	// EnvReplace gives the child only the entries of Env.
+	EnvReplace
+	// EnvInherit gives the child the current environment and ignores Env.
+	EnvInherit
+)
+
+// SpawnOptions describes the process started by SpawnWithOptions.
+type SpawnOptions struct {
+	// Args holds the program and its arguments. Args[0] is looked up in
+	// PATH. It is ignored if Cmd is set.
+	Args []string
+	// Cmd is an existing, unstarted command to run instead of Args. Its
+	// stdin, stdout and stderr must be nil so they can be attached to the
+	// pty.
+	Cmd *exec.Cmd
+
+	// Dir is the working directory of the child; empty means the current
+	// one.
+	Dir     string
+	Env     []string
+	EnvMode EnvMode
+	// Term, if set, is exported to the child as TERM.
+	Term string
+
+	// Rows and Cols give the initial size of the terminal. If either is
+	// zero the pty keeps its default size.
+	Rows, Cols uint16
+	// Pipes runs the child on pipes instead of a pty, for testing how it
+	// behaves when it is not interactive. Stdout and Stderr give access to
+	// the two output streams separately.
+	Pipes bool
+	// Screen attaches a virtual terminal that renders the output of the
+	// child; see ExpectSubprocess.Screen. The terminal is 24x80 unless Rows
+	// and Cols say otherwise.
+	Screen bool
+
+	// Timeout is the session default timeout, as set by SetTimeout.
+	Timeout time.Duration
+	// LineTerminator, if set, is what SendLine appends to each line, as set
+	// by SetLineTerminator.
+	LineTerminator string
+}
+
+func SpawnAtDirectory(command string, directory string, opts ...Option) (*ExpectSubprocess, error) {
+	spawnOpts, err := _parse(command, opts)
 	if err != nil {
 		return nil, err
 	}
-	expect.Cmd.Dir = directory
-	return _start(expect)
+	spawnOpts.Dir = directory
+	return SpawnWithOptions(spawnOpts)
 }
 
-func Command(command string) (*ExpectSubprocess, error) {
-	expect, err := _spawn(command)
+func Command(command string, opts ...Option) (*ExpectSubprocess, error) {
+	spawnOpts, err := _parse(command, opts)
 	if err != nil {
 		return nil, err
 	}
-	return expect, nil
+	return _command(spawnOpts)
 }
 
 func (expect *ExpectSubprocess) Start() error {
@@ -138,22 +430,250 @@ func (expect *ExpectSubprocess) Start() error {
 	return err
 }
 
-func Spawn(command string) (*ExpectSubprocess, error) {
-	expect, err := _spawn(command)
+func Spawn(command string, opts ...Option) (*ExpectSubprocess, error) {
+	spawnOpts, err := _parse(command, opts)
+	if err != nil {
+		return nil, err
+	}
+	return SpawnWithOptions(spawnOpts)
+}
+
+// SpawnArgs starts name with args passed as they are, without the shell-style
+// splitting done by Spawn.
+func SpawnArgs(name string, args ...string) (*ExpectSubprocess, error) {
+	return SpawnWithOptions(SpawnOptions{Args: append([]string{name}, args...)})
+}
+
+func SpawnWithOptions(opts SpawnOptions) (*ExpectSubprocess, error) {
+	expect, err := _command(opts)
 	if err != nil {
 		return nil, err
 	}
 	return _start(expect)
 }
 
-func (expect *ExpectSubprocess) Close() error {
-	if err := expect.Cmd.Process.Kill(); err != nil {
+// NewSession runs the Expect and Send methods over rw, such as a net.Conn, a
+// serial device or an in-memory pipe, instead of a spawned process. The
+// session ends when reading rw fails, and Close closes rw. If rw is the
+// master of a pty, the methods controlling the terminal work on it.
+func NewSession(rw io.ReadWriteCloser, opts ...Option) *ExpectSubprocess {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	expect := newSession(rw)
+	expect.SetTimeout(o.timeout)
+	if f, ok := rw.(*os.File); ok {
+		var size pty.Winsize
+		if ioctl(f, syscall.TIOCGWINSZ, unsafe.Pointer(&size)) == nil {
+			expect.pty = f
+		}
+	}
+	return expect
+}
+
+// newSession returns a session without a child, reading and writing rw.
+func newSession(rw io.ReadWriteCloser) *ExpectSubprocess {
+	expect := new(ExpectSubprocess)
+	expect.buf = &buffer{f: rw, log: &expect.log}
+	return expect
+}
+
+// SetTimeout sets the default limit for Expect, ExpectRegex, ExpectRegexFind,
+// ExpectAny, ExpectSwitch, ReadLine and ReadUntil. Zero, the initial value,
+// means they wait indefinitely.
+func (expect *ExpectSubprocess) SetTimeout(d time.Duration) {
+	expect.timeout.Store(int64(d))
+}
+
+func (expect *ExpectSubprocess) Timeout() time.Duration {
+	return time.Duration(expect.timeout.Load())
+}
+
+// callContext returns the context a single call waiting for pattern runs
+// under, limited by the session default timeout unless opts override it, and
+// that timeout. Loggers are told about pattern until cancel is called.
+func (expect *ExpectSubprocess) callContext(ctx context.Context, pattern string, opts []Option) (context.Context, context.CancelFunc, time.Duration) {
+	o := options{timeout: expect.Timeout()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	done := expect.log.waitFor(pattern)
+	var cancel context.CancelFunc
+	if o.timeout <= 0 {
+		o.timeout = 0
+		ctx, cancel = context.WithCancel(ctx)
+	} else {
+		ctx, cancel = context.WithTimeout(ctx, o.timeout)
+	}
+	return ctx, func() {
+		cancel()
+		done()
+	}, o.timeout
+}
+
+// interrupted describes a call that gave up, either because parent was
+// cancelled or because the call's own timeout or the deadline of parent ran
+// out.
+func (expect *ExpectSubprocess) interrupted(parent context.Context, timeout time.Duration, call, pattern string) error {
+	switch parent.Err() {
+	case nil:
+	case context.DeadlineExceeded:
+		timeout = 0
+	default:
+		return fmt.Errorf("%s cancelled waiting for '%v': %w", call, pattern, parent.Err())
+	}
+	return &TimeoutError{
+		Pattern: pattern,
+		Timeout: timeout,
+		Output:  expect.log.redactOutput(expect.buf.peek(-1)),
+		call:    call,
+	}
+}
+
+// readError turns the error that ended the output into an *EOFError.
+func (expect *ExpectSubprocess) readError(call, pattern string, err error) error {
+	if !isEOF(err) {
 		return err
 	}
-	if err := expect.buf.f.Close(); err != nil {
+	return &EOFError{
+		Pattern:    pattern,
+		ExitStatus: expect.exitStatus(eofGrace),
+		Output:     expect.log.redactOutput(expect.buf.peek(-1)),
+		Err:        err,
+		call:       call,
+	}
+}
+
+// Close kills the child, closes the pty and reaps the child. A child started
+// with SpawnOptions.Pipes has no terminal to hang up on its background jobs,
+// so its whole process group is killed instead.
+func (expect *ExpectSubprocess) Close() error {
+	expect.reap()
+	if expect.Cmd != nil {
+		if expect.pipes {
+			// startPipes makes the child a process group leader.
+			syscall.Kill(-expect.Cmd.Process.Pid, syscall.SIGKILL)
+		}
+		if err := expect.Cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			return err
+		}
+	}
+	err := expect.buf.f.Close()
+	<-expect.exited
+	return err
+}
+
+// terminateGrace is the longest Terminate waits for the child to exit before
+// killing it.
+const terminateGrace = 5 * time.Second
+
+// Terminate asks the child's process group to exit with SIGHUP and SIGTERM.
+// If the child has not exited when ctx is done, or after a few seconds,
+// the whole process group is killed with SIGKILL. The pty is closed and the
+// child reaped in either case.
+func (expect *ExpectSubprocess) Terminate(ctx context.Context) error {
+	expect.reap()
+	if expect.Cmd != nil {
+		// pty.Start and startPipes make the child a process group leader.
+		pgid := expect.Cmd.Process.Pid
+		syscall.Kill(-pgid, syscall.SIGHUP)
+		syscall.Kill(-pgid, syscall.SIGTERM)
+
+		grace := time.NewTimer(terminateGrace)
+		defer grace.Stop()
+		select {
+		case <-expect.exited:
+		case <-ctx.Done():
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		case <-grace.C:
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+	}
+	err := expect.buf.f.Close()
+	<-expect.exited
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// Signal sends sig to the foreground process group of the child's terminal,
+// which is the job a user at the keyboard would interrupt with Ctrl-C. A
+// child started with SpawnOptions.Pipes has no terminal; its own process
+// group gets the signal.
+func (expect *ExpectSubprocess) Signal(sig syscall.Signal) error {
+	if p, ok := expect.buf.f.(remoteProcess); ok {
+		return p.signal(sig)
+	}
+	if expect.pty == nil && expect.pipes {
+		return syscall.Kill(-expect.Cmd.Process.Pid, sig)
+	}
+	if expect.pty == nil {
+		return ErrNoPty
+	}
+	var pgid int32
+	if err := ioctl(expect.pty, syscall.TIOCGPGRP, unsafe.Pointer(&pgid)); err != nil {
 		return err
 	}
-	return nil
+	return syscall.Kill(-int(pgid), sig)
+}
+
+// processState returns the state of the child once it has been reaped, or
+// nil while it is still running.
+func (expect *ExpectSubprocess) processState() *os.ProcessState {
+	expect.reap()
+	if expect.Cmd == nil {
+		return nil
+	}
+	select {
+	case <-expect.exited:
+		return expect.Cmd.ProcessState
+	default:
+		return nil
+	}
+}
+
+// ExitStatus returns the exit code of the child, or -1 if it is still
+// running or was killed by a signal.
+func (expect *ExpectSubprocess) ExitStatus() int {
+	if state := expect.processState(); state != nil {
+		return state.ExitCode()
+	}
+	if p, ok := expect.buf.f.(remoteProcess); ok {
+		select {
+		case <-expect.exited:
+			return p.exitStatus()
+		default:
+		}
+	}
+	return -1
+}
+
+// remoteProcess is implemented by the transports of sessions running a
+// command on another machine, such as SSH.
+type remoteProcess interface {
+	wait() error
+	exitStatus() int
+	signaled() (syscall.Signal, bool)
+	signal(sig syscall.Signal) error
+}
+
+// Signaled reports whether the child was killed by a signal, and which.
+func (expect *ExpectSubprocess) Signaled() (syscall.Signal, bool) {
+	if state := expect.processState(); state != nil {
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return status.Signal(), true
+		}
+	}
+	if p, ok := expect.buf.f.(remoteProcess); ok {
+		select {
+		case <-expect.exited:
+			return p.signaled()
+		default:
+		}
+	}
+	return 0, false
 }
 
 func (expect *ExpectSubprocess) AsyncInteractChannels() (send chan string, receive chan string) {
@@ -162,7 +682,7 @@ func (expect *ExpectSubprocess) AsyncInteractChannels() (send chan string, recei
 
 	go func() {
 		for {
-			str, err := expect.ReadLine()
+			str, err := expect.ReadLine(NoTimeout())
 			if err != nil {
 				close(receive)
 				return
@@ -192,258 +712,756 @@ func (expect *ExpectSubprocess) AsyncInteractChannels() (send chan string, recei
 	return
 }
 
-func (expect *ExpectSubprocess) ExpectRegex(regex string) (bool, error) {
-	return regexp.MatchReader(regex, expect.buf)
+func (expect *ExpectSubprocess) ExpectRegex(regex string, opts ...Option) (bool, error) {
+	return expect.ExpectRegexContext(context.Background(), regex, opts...)
+}
+
+func (expect *ExpectSubprocess) ExpectRegexContext(ctx context.Context, regex string, opts ...Option) (bool, error) {
+	re, err := regexp.Compile(regex)
+	if err != nil {
+		return false, err
+	}
+	callCtx, cancel, timeout := expect.callContext(ctx, regex, opts)
+	defer cancel()
+	rr := &runeReader{ctx: callCtx, buf: expect.buf}
+	loc := re.FindReaderSubmatchIndex(rr)
+	if loc == nil {
+		if callCtx.Err() != nil {
+			return false, expect.interrupted(ctx, timeout, "ExpectRegex", regex)
+		}
+		// Output that ends without a match is not an error.
+		if err := expect.buf.error(); !isEOF(err) {
+			return false, err
+		}
+		return false, nil
+	}
+	seen := expect.buf.peek(loc[1])
+	expect.setMatch(string(seen[:loc[0]]), submatches(seen, loc))
+	expect.buf.discard(loc[1])
+	return true, nil
 }
 
-func (expect *ExpectSubprocess) expectRegexFind(regex string, output bool) ([]string, string, error) {
+func (expect *ExpectSubprocess) expectRegexFind(ctx context.Context, regex string, opts []Option) ([]string, string, error) {
 	re, err := regexp.Compile(regex)
 	if err != nil {
 		return nil, "", err
 	}
-	expect.buf.StartCollecting()
-	pairs := re.FindReaderSubmatchIndex(expect.buf)
-	stringIndexedInto := expect.buf.StopCollecting()
+	callCtx, cancel, timeout := expect.callContext(ctx, regex, opts)
+	defer cancel()
+	rr := &runeReader{ctx: callCtx, buf: expect.buf}
+	pairs := re.FindReaderSubmatchIndex(rr)
+	// Everything the regexp looked at is still unread; the match indexes
+	// are byte offsets into it.
+	stringIndexedInto := string(expect.buf.peek(rr.pos))
+	if pairs == nil && callCtx.Err() != nil {
+		return nil, expect.log.redactString(stringIndexedInto), expect.interrupted(ctx, timeout, "ExpectRegex", regex)
+	}
 	l := len(pairs)
 	numPairs := l / 2
 	result := make([]string, numPairs)
 	for i := 0; i < numPairs; i += 1 {
-		result[i] = stringIndexedInto[pairs[i*2]:pairs[i*2+1]]
+		if pairs[i*2] >= 0 {
+			result[i] = stringIndexedInto[pairs[i*2]:pairs[i*2+1]]
+		}
 	}
 	// convert indexes to strings
 
 	if len(result) == 0 {
-		err = fmt.Errorf("ExpectRegex didn't find regex '%v'.", regex)
+		err = &NoMatchError{
+			Pattern: regex,
+			Output:  expect.log.redactOutput([]byte(stringIndexedInto)),
+			Err:     expect.readError("ExpectRegexFind", regex, expect.buf.error()),
+		}
 	} else {
 		// The number in pairs[1] is an index of a first
 		// character outside the whole match
-		putBackIdx := pairs[1]
-		if len(stringIndexedInto) > putBackIdx {
-			stringToPutBack := stringIndexedInto[putBackIdx:]
-			stringIndexedInto = stringIndexedInto[:putBackIdx]
-			expect.buf.PutBack([]byte(stringToPutBack))
-		}
+		stringIndexedInto = stringIndexedInto[:pairs[1]]
+		expect.setMatch(stringIndexedInto[:pairs[0]], result)
+		expect.buf.discard(pairs[1])
 	}
-	return result, stringIndexedInto, err
+	return result, expect.log.redactString(stringIndexedInto), err
 }
 
-func (expect *ExpectSubprocess) expectTimeoutRegexFind(regex string, timeout time.Duration) (result []string, out string, err error) {
-	t := make(chan bool)
-	go func() {
-		result, out, err = expect.ExpectRegexFindWithOutput(regex)
-		t <- false
-	}()
-	go func() {
-		time.Sleep(timeout)
-		err = fmt.Errorf("ExpectRegex timed out after %v finding '%v'.\nOutput:\n%s", timeout, regex, expect.Collect())
-		t <- true
-	}()
-	<-t
-	return result, out, err
+func (expect *ExpectSubprocess) ExpectRegexFind(regex string, opts ...Option) ([]string, error) {
+	return expect.ExpectRegexFindContext(context.Background(), regex, opts...)
 }
 
-func (expect *ExpectSubprocess) ExpectRegexFind(regex string) ([]string, error) {
-	result, _, err := expect.expectRegexFind(regex, false)
+func (expect *ExpectSubprocess) ExpectRegexFindContext(ctx context.Context, regex string, opts ...Option) ([]string, error) {
+	result, _, err := expect.expectRegexFind(ctx, regex, opts)
 	return result, err
 }
 
 func (expect *ExpectSubprocess) ExpectTimeoutRegexFind(regex string, timeout time.Duration) ([]string, error) {
-	result, _, err := expect.expectTimeoutRegexFind(regex, timeout)
-	return result, err
+	return expect.ExpectRegexFind(regex, WithTimeout(timeout))
+}
+
+func (expect *ExpectSubprocess) ExpectRegexFindWithOutput(regex string, opts ...Option) ([]string, string, error) {
+	return expect.ExpectRegexFindWithOutputContext(context.Background(), regex, opts...)
 }
 
-func (expect *ExpectSubprocess) ExpectRegexFindWithOutput(regex string) ([]string, string, error) {
-	return expect.expectRegexFind(regex, true)
+func (expect *ExpectSubprocess) ExpectRegexFindWithOutputContext(ctx context.Context, regex string, opts ...Option) ([]string, string, error) {
+	return expect.expectRegexFind(ctx, regex, opts)
 }
 
 func (expect *ExpectSubprocess) ExpectTimeoutRegexFindWithOutput(regex string, timeout time.Duration) ([]string, string, error) {
-	return expect.expectTimeoutRegexFind(regex, timeout)
+	return expect.ExpectRegexFindWithOutput(regex, WithTimeout(timeout))
+}
+
+func (expect *ExpectSubprocess) ExpectTimeout(searchString string, timeout time.Duration) (e error) {
+	return expect.Expect(searchString, WithTimeout(timeout))
+}
+
+func (expect *ExpectSubprocess) Expect(searchString string, opts ...Option) (e error) {
+	return expect.ExpectContext(context.Background(), searchString, opts...)
+}
+
+func (expect *ExpectSubprocess) ExpectContext(ctx context.Context, searchString string, opts ...Option) (e error) {
+	if len(searchString) < 1 {
+		return ErrEmptySearch
+	}
+	callCtx, cancel, timeout := expect.callContext(ctx, searchString, opts)
+	defer cancel()
+	search := []byte(searchString)
+	var before string
+	e = expect.buf.wait(callCtx, func(data []byte, atEOF bool) (int, bool) {
+		i := bytes.Index(data, search)
+		if i < 0 {
+			return 0, false
+		}
+		before = string(data[:i])
+		return i + len(search), true
+	})
+	if e != nil && callCtx.Err() != nil {
+		return expect.interrupted(ctx, timeout, "Expect", searchString)
+	}
+	if e != nil {
+		return expect.readError("Expect", searchString, e)
+	}
+	expect.setMatch(before, []string{searchString})
+	return nil
 }
 
-func buildKMPTable(searchString string) []int {
-	pos := 2
-	cnd := 0
-	length := len(searchString)
+// Pattern is a literal string or regular expression that ExpectAny can wait
+// for. Use Literal and Regexp to build one.
+type Pattern interface {
+	// find returns the submatch index pairs of the leftmost match in b, in the
+	// form returned by regexp.FindSubmatchIndex, or nil if there is none.
+	find(b []byte) []int
+	String() string
+}
+
+type literalPattern string
+
+// Literal returns a Pattern matching s exactly.
+func Literal(s string) Pattern {
+	return literalPattern(s)
+}
 
-	var table []int
-	if length < 2 {
-		length = 2
+func (p literalPattern) find(b []byte) []int {
+	i := bytes.Index(b, []byte(p))
+	if i < 0 {
+		return nil
 	}
+	return []int{i, i + len(p)}
+}
+
+func (p literalPattern) String() string {
+	return string(p)
+}
 
-	table = make([]int, length)
-	table[0] = -1
-	table[1] = 0
+type regexpPattern struct {
+	*regexp.Regexp
+}
 
-	for pos < len(searchString) {
-		if searchString[pos-1] == searchString[cnd] {
-			cnd += 1
-			table[pos] = cnd
-			pos += 1
-		} else if cnd > 0 {
-			cnd = table[cnd]
-		} else {
-			table[pos] = 0
-			pos += 1
+// Regexp returns a Pattern matching re. Submatches are reported by ExpectAny.
+func Regexp(re *regexp.Regexp) Pattern {
+	return regexpPattern{re}
+}
+
+func (p regexpPattern) find(b []byte) []int {
+	return p.FindSubmatchIndex(b)
+}
+
+// ExpectAny waits until one of patterns appears in the output and returns the
+// index of the pattern that matched first, its submatches (the whole match
+// followed by any regexp groups) and the output preceding the match. Output
+// after the match is left unread. If several patterns match, the one starting
+// earliest in the stream wins, with ties going to the lower index.
+func (expect *ExpectSubprocess) ExpectAny(patterns ...Pattern) (int, []string, string, error) {
+	return expect.ExpectAnyContext(context.Background(), patterns...)
+}
+
+func (expect *ExpectSubprocess) ExpectAnyContext(ctx context.Context, patterns ...Pattern) (int, []string, string, error) {
+	if len(patterns) == 0 {
+		return -1, nil, "", ErrEmptySearch
+	}
+	for _, p := range patterns {
+		if p.String() == "" {
+			return -1, nil, "", ErrEmptySearch
 		}
 	}
-	return table
+	callCtx, cancel, timeout := expect.callContext(ctx, fmt.Sprint(patterns), nil)
+	defer cancel()
+	index, match, before, err := expect.expectAny(callCtx, patterns)
+	if err != nil && callCtx.Err() != nil {
+		return -1, nil, "", expect.interrupted(ctx, timeout, "ExpectAny", fmt.Sprint(patterns))
+	}
+	if err != nil {
+		return -1, nil, "", expect.readError("ExpectAny", fmt.Sprint(patterns), err)
+	}
+	return index, match, before, nil
 }
 
-func (expect *ExpectSubprocess) ExpectTimeout(searchString string, timeout time.Duration) (e error) {
-	result := make(chan error)
-	go func() {
-		result <- expect.Expect(searchString)
-	}()
-	select {
-	case e = <-result:
-	case <-time.After(timeout):
-		e = fmt.Errorf("Expect timed out after %v waiting for '%v'.\nOutput:\n%s", timeout, searchString, expect.Collect())
+func (expect *ExpectSubprocess) expectAny(ctx context.Context, patterns []Pattern) (int, []string, string, error) {
+	index, match, before := -1, []string(nil), ""
+	err := expect.buf.wait(ctx, func(data []byte, atEOF bool) (int, bool) {
+		var loc []int
+		for i, p := range patterns {
+			if l := p.find(data); l != nil && (loc == nil || l[0] < loc[0]) {
+				index, loc = i, l
+			}
+		}
+		if loc == nil {
+			return 0, false
+		}
+		match = submatches(data, loc)
+		before = string(data[:loc[0]])
+		return loc[1], true
+	})
+	if err != nil {
+		return -1, nil, "", err
 	}
-	return e
+	expect.setMatch(before, match)
+	return index, match, before, nil
 }
 
-func (expect *ExpectSubprocess) Expect(searchString string) (e error) {
-	target := len(searchString)
-	if target < 1 {
-		return ErrEmptySearch
+// submatches returns the text of the submatches of b at loc, as returned by
+// Pattern.find.
+func submatches(b []byte, loc []int) []string {
+	match := make([]string, len(loc)/2)
+	for i := range match {
+		if loc[i*2] >= 0 {
+			match[i] = string(b[loc[i*2]:loc[i*2+1]])
+		}
 	}
-	chunk := make([]byte, target*2)
-	if expect.outputBuffer != nil {
-		expect.outputBuffer = expect.outputBuffer[0:]
+	return match
+}
+
+var (
+	// EOF is a Case pattern matching the end of the child's output.
+	EOF Pattern = specialPattern("EOF")
+	// Timeout is a Case pattern matching an ExpectSwitch that ran out of time.
+	Timeout Pattern = specialPattern("TIMEOUT")
+
+	// Continue, returned from a Case handler, makes ExpectSwitch wait for
+	// its cases again, like exp_continue in Tcl expect.
+	Continue = errors.New("gexpect: continue")
+)
+
+type specialPattern string
+
+func (p specialPattern) find(b []byte) []int {
+	return nil
+}
+
+func (p specialPattern) String() string {
+	return string(p)
+}
+
+// Case is one branch of an ExpectSwitch. Handler is called with the
+// submatches of Pattern (nil for EOF and Timeout); a nil Handler simply ends
+// the switch.
+type Case struct {
+	Pattern Pattern
+	Handler func(match []string) error
+}
+
+// ExpectSwitch waits for the first of cases to match and runs its handler,
+// repeating for as long as handlers return Continue. It returns the error of
+// the last handler, or the read error if no EOF case was given. The session
+// timeout applies to each wait and, as in Tcl expect, starts again every time
+// a handler returns Continue.
+func (expect *ExpectSubprocess) ExpectSwitch(cases ...Case) error {
+	return expect.expectSwitch(context.Background(), nil, cases)
+}
+
+// ExpectSwitchContext is ExpectSwitch bounded by ctx. A Timeout case also
+// runs when the deadline of ctx passes.
+func (expect *ExpectSubprocess) ExpectSwitchContext(ctx context.Context, cases ...Case) error {
+	return expect.expectSwitch(ctx, nil, cases)
+}
+
+// ExpectTimeoutSwitch is ExpectSwitch with timeout in place of the session
+// default.
+func (expect *ExpectSubprocess) ExpectTimeoutSwitch(timeout time.Duration, cases ...Case) error {
+	return expect.expectSwitch(context.Background(), []Option{WithTimeout(timeout)}, cases)
+}
+
+func (expect *ExpectSubprocess) expectSwitch(ctx context.Context, opts []Option, cases []Case) error {
+	var patterns []Pattern
+	var caseIndex []int
+	eofCase, timeoutCase := -1, -1
+	for i, c := range cases {
+		switch c.Pattern {
+		case EOF:
+			eofCase = i
+		case Timeout:
+			timeoutCase = i
+		default:
+			if c.Pattern == nil || c.Pattern.String() == "" {
+				return ErrEmptySearch
+			}
+			patterns = append(patterns, c.Pattern)
+			caseIndex = append(caseIndex, i)
+		}
 	}
-	m := 0
-	i := 0
-	// Build KMP Table
-	table := buildKMPTable(searchString)
 
 	for {
-		n, err := expect.buf.Read(chunk)
-		if n == 0 && err != nil {
+		iterCtx, cancel, timeout := expect.callContext(ctx, fmt.Sprint(patterns), opts)
+		index, match, _, err := expect.expectAny(iterCtx, patterns)
+		timedOut := err != nil && iterCtx.Err() == context.DeadlineExceeded
+		cancel()
+
+		var c Case
+		switch {
+		case err == nil:
+			c = cases[caseIndex[index]]
+		case timedOut && timeoutCase >= 0:
+			c = cases[timeoutCase]
+		case isEOF(err) && eofCase >= 0:
+			c = cases[eofCase]
+		case err != nil && iterCtx.Err() != nil:
+			return expect.interrupted(ctx, timeout, "ExpectSwitch", fmt.Sprint(patterns))
+		default:
+			return expect.readError("ExpectSwitch", fmt.Sprint(patterns), err)
+		}
+		if c.Handler == nil {
+			return nil
+		}
+		if err := c.Handler(match); err != Continue {
 			return err
 		}
-		if expect.outputBuffer != nil {
-			expect.outputBuffer = append(expect.outputBuffer, chunk[:n]...)
-		}
-		offset := m + i
-		for m+i-offset < n {
-			if searchString[i] == chunk[m+i-offset] {
-				i += 1
-				if i == target {
-					unreadIndex := m + i - offset
-					if len(chunk) > unreadIndex {
-						expect.buf.PutBack(chunk[unreadIndex:n])
-					}
-					return nil
-				}
-			} else {
-				m += i - table[i]
-				if table[i] > -1 {
-					i = table[i]
-				} else {
-					i = 0
-				}
+	}
+}
+
+// isEOF reports whether err marks the end of the child's output. Linux
+// reports a closed pty with EIO rather than io.EOF.
+func isEOF(err error) bool {
+	return err == io.EOF || errors.Is(err, syscall.EIO) || errors.Is(err, os.ErrClosed) ||
+		errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
+
+func (expect *ExpectSubprocess) Send(command string) error {
+	return expect.send([]byte(command))
+}
+
+// SetSendDelay makes Send and SendLine pause between characters, for programs
+// that drop input typed too fast, like serial consoles. Each pause lasts
+// delay plus a random part of up to jitter; a CR LF counts as one character.
+// Zero for both, the initial value, sends everything at once.
+func (expect *ExpectSubprocess) SetSendDelay(delay, jitter time.Duration) {
+	expect.sendDelay.Store(int64(delay))
+	expect.sendJitter.Store(int64(jitter))
+}
+
+// send is write with the pauses set by SetSendDelay.
+func (expect *ExpectSubprocess) send(p []byte) error {
+	delay := time.Duration(expect.sendDelay.Load())
+	jitter := expect.sendJitter.Load()
+	if delay <= 0 && jitter <= 0 {
+		_, err := expect.write(p)
+		return err
+	}
+	expect.log.record(dirSend, p)
+	for len(p) > 0 {
+		_, size := utf8.DecodeRune(p)
+		// A line ending goes in one write, as some transports such as
+		// telnet encode a CR differently when an LF follows it.
+		if bytes.HasPrefix(p, []byte("\r\n")) {
+			size = 2
+		}
+		if _, err := expect.buf.f.Write(p[:size]); err != nil {
+			return err
+		}
+		p = p[size:]
+		if len(p) > 0 {
+			pause := delay
+			if jitter > 0 {
+				pause += time.Duration(rand.Int63n(jitter))
 			}
+			time.Sleep(pause)
 		}
 	}
+	return nil
 }
 
-func (expect *ExpectSubprocess) Send(command string) error {
-	_, err := io.WriteString(expect.buf.f, command)
-	return err
+// write passes p on to the loggers and sends it to the child. It is logged
+// first so that it comes before the child's echo of it.
+func (expect *ExpectSubprocess) write(p []byte) (int, error) {
+	expect.log.record(dirSend, p)
+	return expect.buf.f.Write(p)
 }
 
+// writerFunc adapts a function to io.Writer.
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// Capture starts recording all output consumed by the Expect and Read
+// methods, until the next call to Collect.
 func (expect *ExpectSubprocess) Capture() {
-	if expect.outputBuffer == nil {
-		expect.outputBuffer = make([]byte, 0)
+	expect.buf.mu.Lock()
+	defer expect.buf.mu.Unlock()
+	if expect.buf.captured == nil {
+		expect.buf.captured = make([]byte, 0)
 	}
 }
 
 func (expect *ExpectSubprocess) Collect() []byte {
-	collectOutput := make([]byte, len(expect.outputBuffer))
-	copy(collectOutput, expect.outputBuffer)
-	expect.outputBuffer = nil
+	expect.buf.mu.Lock()
+	defer expect.buf.mu.Unlock()
+	collectOutput := expect.log.redactOutput(expect.buf.captured)
+	expect.buf.captured = nil
 	return collectOutput
 }
 
 func (expect *ExpectSubprocess) SendLine(command string) error {
-	_, err := io.WriteString(expect.buf.f, command+"\r\n")
-	return err
+	return expect.send([]byte(command + expect.LineTerminator()))
 }
 
-func (expect *ExpectSubprocess) Interact() {
-	defer expect.Cmd.Wait()
-	io.Copy(os.Stdout, &expect.buf.b)
-	go io.Copy(os.Stdout, expect.buf.f)
-	go io.Copy(expect.buf.f, os.Stdin)
+// SetLineTerminator sets what SendLine appends to each line. The initial value
+// is "\r\n"; programs reading the terminal in raw mode usually want "\r", and
+// on a terminal that turns "\r" into "\n" itself "\n" avoids entering an
+// extra empty line. An empty terminator restores the default.
+func (expect *ExpectSubprocess) SetLineTerminator(terminator string) {
+	expect.mu.Lock()
+	defer expect.mu.Unlock()
+	expect.terminator = terminator
 }
 
-func (expect *ExpectSubprocess) ReadUntil(delim byte) ([]byte, error) {
-	join := make([]byte, 0, 512)
-	chunk := make([]byte, 255)
-
-	for {
-		n, err := expect.buf.Read(chunk)
+func (expect *ExpectSubprocess) LineTerminator() string {
+	expect.mu.Lock()
+	defer expect.mu.Unlock()
+	if expect.terminator == "" {
+		return "\r\n"
+	}
+	return expect.terminator
+}
 
-		for i := 0; i < n; i++ {
-			if chunk[i] == delim {
-				if len(chunk) > i+1 {
-					expect.buf.PutBack(chunk[i+1:n])
-				}
-				return join, nil
-			} else {
-				join = append(join, chunk[i])
+// Interact connects the child to the standard input and output of the current
+// process until it exits. If stdin is a terminal, its size, and any later
+// change to it, is passed on to the child.
+func (expect *ExpectSubprocess) Interact() {
+	defer expect.Wait()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer func() {
+		signal.Stop(winch)
+		close(winch)
+	}()
+	winch <- syscall.SIGWINCH
+	go func() {
+		for range winch {
+			if size, err := pty.GetsizeFull(os.Stdin); err == nil {
+				expect.SetWinSize(size.Rows, size.Cols)
 			}
 		}
+	}()
+	go io.Copy(writerFunc(expect.write), os.Stdin)
+	io.Copy(os.Stdout, expect.buf)
+}
 
-		if err != nil {
-			return join, err
+// terminal is implemented by the transports of sessions that have a terminal
+// size without a pty, such as telnet.
+type terminal interface {
+	setWinSize(rows, cols uint16) error
+	getWinSize() (rows, cols uint16, err error)
+}
+
+// SetWinSize changes the size of the child's terminal. The kernel delivers
+// SIGWINCH to the child's foreground process group if the size changed. A
+// telnet session sends the new size to the server instead.
+func (expect *ExpectSubprocess) SetWinSize(rows, cols uint16) error {
+	if t, ok := expect.buf.f.(terminal); ok {
+		return t.setWinSize(rows, cols)
+	}
+	if expect.pty == nil {
+		return ErrNoPty
+	}
+	if err := setWinsize(expect.pty, &pty.Winsize{Rows: rows, Cols: cols}); err != nil {
+		return err
+	}
+	if expect.screen != nil {
+		expect.screen.Resize(int(rows), int(cols))
+	}
+	return nil
+}
+
+func (expect *ExpectSubprocess) GetWinSize() (rows, cols uint16, err error) {
+	if t, ok := expect.buf.f.(terminal); ok {
+		return t.getWinSize()
+	}
+	if expect.pty == nil {
+		return 0, 0, ErrNoPty
+	}
+	var size pty.Winsize
+	if err := ioctl(expect.pty, syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0, 0, err
+	}
+	return size.Rows, size.Cols, nil
+}
+
+// setEcho turns the echo of the child's terminal on or off. Programs reading
+// lines with readline echo them themselves only if it is on.
+func (expect *ExpectSubprocess) setEcho(on bool) error {
+	if expect.pty == nil {
+		return ErrNoPty
+	}
+	var t syscall.Termios
+	if err := ioctl(expect.pty, ioctlGetTermios, unsafe.Pointer(&t)); err != nil {
+		return err
+	}
+	if on {
+		t.Lflag |= syscall.ECHO
+	} else {
+		t.Lflag &^= syscall.ECHO
+	}
+	return ioctl(expect.pty, ioctlSetTermios, unsafe.Pointer(&t))
+}
+
+func setWinsize(f *os.File, size *pty.Winsize) error {
+	return ioctl(f, syscall.TIOCSWINSZ, unsafe.Pointer(size))
+}
+
+// ioctl issues an ioctl on the pty master. Unlike the helpers in the pty
+// package it does not call f.Fd, which would put the descriptor back into
+// blocking mode.
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (expect *ExpectSubprocess) ReadUntil(delim byte, opts ...Option) ([]byte, error) {
+	return expect.ReadUntilContext(context.Background(), delim, opts...)
+}
+
+func (expect *ExpectSubprocess) ReadUntilContext(ctx context.Context, delim byte, opts ...Option) ([]byte, error) {
+	callCtx, cancel, timeout := expect.callContext(ctx, string(delim), opts)
+	defer cancel()
+	var join []byte
+	found := false
+	err := expect.buf.wait(callCtx, func(data []byte, atEOF bool) (int, bool) {
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			join = append(join, data[:i]...)
+			found = true
+			return i + 1, true
+		}
+		if atEOF {
+			join = append(join, data...)
+			return len(data), true
 		}
+		return 0, false
+	})
+	if err != nil && callCtx.Err() != nil {
+		return nil, expect.interrupted(ctx, timeout, "ReadUntil", string(delim))
 	}
+	if err == nil && !found {
+		err = expect.buf.error()
+	}
+	if err != nil {
+		return join, expect.readError("ReadUntil", string(delim), err)
+	}
+	return join, nil
 }
 
+// Wait waits for the child to exit, or for the output of a session without
+// one to end.
 func (expect *ExpectSubprocess) Wait() error {
-	return expect.Cmd.Wait()
+	return expect.WaitContext(context.Background())
 }
 
-func (expect *ExpectSubprocess) ReadLine() (string, error) {
-	str, err := expect.ReadUntil('\n')
+// WaitContext waits for the child to exit. If ctx is done first the child is
+// killed, reaped, and the context's error returned.
+func (expect *ExpectSubprocess) WaitContext(ctx context.Context) error {
+	expect.reap()
+	if expect.exited == nil {
+		// Not started yet.
+		return expect.Cmd.Wait()
+	}
+	select {
+	case <-expect.exited:
+		return expect.waitErr
+	case <-ctx.Done():
+		if expect.Cmd != nil {
+			expect.Cmd.Process.Kill()
+		} else {
+			expect.buf.f.Close()
+		}
+		<-expect.exited
+		return fmt.Errorf("Wait cancelled: %w", ctx.Err())
+	}
+}
+
+// reap starts waiting for the child in the background, once; exited is closed
+// when it has been reaped. A session without a child ends with its output.
+// Nothing happens until a child made by Command is started.
+func (expect *ExpectSubprocess) reap() {
+	if expect.Cmd != nil && expect.Cmd.Process == nil {
+		return
+	}
+	expect.reapOnce.Do(func() {
+		expect.exited = make(chan struct{})
+		go func() {
+			if expect.Cmd != nil {
+				expect.waitErr = expect.Cmd.Wait()
+			} else if err := expect.buf.wait(context.Background(), func([]byte, bool) (int, bool) {
+				return 0, false
+			}); !isEOF(err) {
+				expect.waitErr = err
+			} else if p, ok := expect.buf.f.(remoteProcess); ok {
+				expect.waitErr = p.wait()
+			}
+			close(expect.exited)
+		}()
+	})
+}
+
+// exitStatus returns the exit status of the child if it exits within grace,
+// or -1.
+func (expect *ExpectSubprocess) exitStatus(grace time.Duration) int {
+	expect.reap()
+	select {
+	case <-expect.exited:
+		return expect.ExitStatus()
+	case <-time.After(grace):
+		return -1
+	}
+}
+
+func (expect *ExpectSubprocess) ReadLine(opts ...Option) (string, error) {
+	return expect.ReadLineContext(context.Background(), opts...)
+}
+
+func (expect *ExpectSubprocess) ReadLineContext(ctx context.Context, opts ...Option) (string, error) {
+	str, err := expect.ReadUntilContext(ctx, '\n', opts...)
 	return string(str), err
 }
 
 func _start(expect *ExpectSubprocess) (*ExpectSubprocess, error) {
-	f, err := pty.Start(expect.Cmd)
+	if expect.pipes {
+		if err := startPipes(expect); err != nil {
+			return nil, err
+		}
+		return expect, nil
+	}
+	f, err := pty.StartWithSize(expect.Cmd, expect.size)
 	if err != nil {
 		return nil, err
 	}
+	f, err = pollable(f)
+	if err != nil {
+		return nil, err
+	}
+	expect.pty = f
 	expect.buf.f = f
 
 	return expect, nil
 }
 
-func _spawn(command string) (*ExpectSubprocess, error) {
-	wrapper := new(ExpectSubprocess)
-
-	wrapper.outputBuffer = nil
-
-	splitArgs, err := shell.Split(command)
+// pollable returns a non-blocking copy of the pty master. pty.Start leaves the
+// descriptor in blocking mode, where closing it does not interrupt the reader
+// goroutine.
+func pollable(f *os.File) (*os.File, error) {
+	defer f.Close()
+	fd, err := syscall.Dup(int(f.Fd()))
 	if err != nil {
 		return nil, err
 	}
-	numArguments := len(splitArgs) - 1
-	if numArguments < 0 {
-		return nil, errors.New("gexpect: No command given to spawn")
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), f.Name()), nil
+}
+
+func _parse(command string, opts []Option) (SpawnOptions, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
-	path, err := exec.LookPath(splitArgs[0])
+	splitArgs, err := shell.Split(command)
 	if err != nil {
-		return nil, err
+		return SpawnOptions{}, err
 	}
+	return SpawnOptions{Args: splitArgs, Timeout: o.timeout}, nil
+}
 
-	if numArguments >= 1 {
-		wrapper.Cmd = exec.Command(path, splitArgs[1:]...)
+func _command(opts SpawnOptions) (*ExpectSubprocess, error) {
+	wrapper := new(ExpectSubprocess)
+	wrapper.SetTimeout(opts.Timeout)
+	wrapper.SetLineTerminator(opts.LineTerminator)
+
+	if opts.Cmd != nil {
+		wrapper.Cmd = opts.Cmd
 	} else {
-		wrapper.Cmd = exec.Command(path)
+		numArguments := len(opts.Args) - 1
+		if numArguments < 0 {
+			return nil, errors.New("gexpect: No command given to spawn")
+		}
+		path, err := exec.LookPath(opts.Args[0])
+		if err != nil {
+			return nil, err
+		}
+		wrapper.Cmd = exec.Command(path, opts.Args[1:]...)
+	}
+
+	if opts.Dir != "" {
+		wrapper.Cmd.Dir = opts.Dir
+	}
+	if opts.EnvMode != EnvInherit || opts.Term != "" {
+		env := wrapper.Cmd.Env
+		if env == nil {
+			env = os.Environ()
+		}
+		switch opts.EnvMode {
+		case EnvMerge:
+			// exec.Cmd uses the last of several entries with the same key.
+			env = append(env[:len(env):len(env)], opts.Env...)
+		case EnvReplace:
+			env = append([]string(nil), opts.Env...)
+		}
+		if opts.Term != "" {
+			env = append(env, "TERM="+opts.Term)
+		}
+		wrapper.Cmd.Env = env
+	}
+	if opts.Screen && (opts.Rows == 0 || opts.Cols == 0) {
+		opts.Rows, opts.Cols = 24, 80
+	}
+	if opts.Rows > 0 && opts.Cols > 0 {
+		wrapper.size = &pty.Winsize{Rows: opts.Rows, Cols: opts.Cols}
+	}
+	wrapper.pipes = opts.Pipes
+	wrapper.buf = &buffer{log: &wrapper.log}
+	if opts.Screen {
+		wrapper.screen = NewScreen(int(opts.Rows), int(opts.Cols))
+		wrapper.buf.screen = wrapper.screen
 	}
-	wrapper.buf = new(buffer)
 
 	return wrapper, nil
 }
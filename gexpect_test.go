@@ -4,9 +4,15 @@ package gexpect
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -109,6 +115,33 @@ func TestCommandStart(t *testing.T) {
 	child.Expect("Hello World")
 }
 
+func TestCommandExitStatusBeforeStart(t *testing.T) {
+	t.Logf("Testing ExitStatus before Start...")
+	child, err := Command("sh -c 'exit 3'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := child.ExitStatus(); status != -1 {
+		t.Fatalf("Expected exit status -1 before Start, got %d", status)
+	}
+	if _, ok := child.Signaled(); ok {
+		t.Fatal("Expected no signal before Start")
+	}
+	if err := child.Wait(); err == nil {
+		t.Fatal("Expected Wait to fail before Start")
+	}
+	if err := child.Start(); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if err := child.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("Expected an *exec.ExitError, got %v", err)
+	}
+	if status := child.ExitStatus(); status != 3 {
+		t.Fatalf("Expected exit status 3, got %d", status)
+	}
+}
+
 var regexMatchTests = []struct {
 	re   string
 	good string
@@ -417,3 +450,552 @@ func TestBufferReadRune(t *testing.T) {
 	}
 
 }
+
+func TestExpectContextCancel(t *testing.T) {
+	t.Logf("Testing Expect with a cancelled context...")
+	child, err := Spawn("sh -c 'sleep 5 && echo too late'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	err = child.ExpectContext(ctx, "too late")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected a context.Canceled error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Expect took %v to notice the cancellation", elapsed)
+	}
+}
+
+func TestReadLineContextDeadline(t *testing.T) {
+	t.Logf("Testing ReadLine with a context deadline...")
+	child, err := Spawn("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = child.ReadLineContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a context.DeadlineExceeded error, got %v", err)
+	}
+
+	// The session is still usable after the deadline passed.
+	child.SendLine("still here")
+	line, err := child.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "still here\r" {
+		t.Fatalf("expected 'still here\\r', got %q", line)
+	}
+}
+
+func TestExpectContextDeadlineTimeoutError(t *testing.T) {
+	t.Logf("Testing Expect past a context deadline returns a TimeoutError...")
+	child, err := Spawn("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+
+	child.Send("partial")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = child.ExpectContext(ctx, "never", WithTimeout(5*time.Second))
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a *TimeoutError, got %v", err)
+	}
+	if string(timeoutErr.Output) != "partial" {
+		t.Fatalf("Expected the unread output 'partial', got %q", timeoutErr.Output)
+	}
+}
+
+func TestWaitContext(t *testing.T) {
+	t.Logf("Testing WaitContext kills the child on cancellation...")
+	child, err := Spawn("sleep 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = child.WaitContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a context.DeadlineExceeded error, got %v", err)
+	}
+	if child.Cmd.ProcessState == nil {
+		t.Fatalf("Child was not reaped")
+	}
+}
+
+func TestExpectAny(t *testing.T) {
+	t.Logf("Testing ExpectAny...")
+	child, err := Spawn(`sh -c 'echo "Password:"; echo "Permission denied (publickey)"; echo "$ "'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	patterns := []Pattern{
+		Literal("$ "),
+		Regexp(regexp.MustCompile(`Permission denied \((\w+)\)`)),
+		Literal("continue connecting"),
+	}
+	index, match, before, err := child.ExpectAny(patterns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 1 {
+		t.Fatalf("Expected pattern 1 to match, got %d", index)
+	}
+	if len(match) != 2 || match[0] != "Permission denied (publickey)" || match[1] != "publickey" {
+		t.Fatalf("Unexpected submatches %q", match)
+	}
+	if before != "Password:\r\n" {
+		t.Fatalf("Unexpected text before the match %q", before)
+	}
+
+	// The rest of the output stays available to the next call.
+	index, _, before, err = child.ExpectAny(patterns...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 0 || before != "\r\n" {
+		t.Fatalf("Expected the prompt after a newline, got pattern %d after %q", index, before)
+	}
+}
+
+func TestExpectAnyEarliestMatchWins(t *testing.T) {
+	t.Logf("Testing ExpectAny prefers the earliest match...")
+	child, err := Spawn("echo foo bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, match, _, err := child.ExpectAny(Literal("bar"), Literal("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 1 || match[0] != "foo" {
+		t.Fatalf("Expected 'foo' to match first, got pattern %d (%q)", index, match)
+	}
+	if _, _, _, err = child.ExpectAny(Literal("")); err != ErrEmptySearch {
+		t.Fatalf("Expected empty search error, got %v", err)
+	}
+}
+
+func TestExpectSwitch(t *testing.T) {
+	t.Logf("Testing ExpectSwitch with exp_continue style handlers...")
+	child, err := Spawn(`sh -c 'printf "Are you sure you want to continue connecting (yes/no)? "; read a; printf "Password: "; read p; echo "got $a $p"; printf "$ "'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen []string
+	err = child.ExpectTimeoutSwitch(5*time.Second,
+		Case{Literal("continue connecting (yes/no)? "), func(m []string) error {
+			seen = append(seen, "hostkey")
+			child.Send("yes\n")
+			return Continue
+		}},
+		Case{Literal("Password: "), func(m []string) error {
+			seen = append(seen, "password")
+			child.Send("hunter2\n")
+			return Continue
+		}},
+		Case{Regexp(regexp.MustCompile(`got (\w+) (\w+)`)), func(m []string) error {
+			seen = append(seen, m[1]+" "+m[2])
+			return Continue
+		}},
+		Case{Literal("$ "), nil},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(seen, ",") != "hostkey,password,yes hunter2" {
+		t.Fatalf("Handlers ran in an unexpected order: %q", seen)
+	}
+}
+
+func TestExpectSwitchSpecialCases(t *testing.T) {
+	t.Logf("Testing ExpectSwitch EOF and Timeout cases...")
+	child, err := Spawn("echo done")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sawEOF := false
+	err = child.ExpectSwitch(
+		Case{Literal("never printed"), nil},
+		Case{EOF, func(m []string) error {
+			sawEOF = true
+			return nil
+		}},
+	)
+	if err != nil || !sawEOF {
+		t.Fatalf("Expected the EOF case to run, got %v", err)
+	}
+
+	child, err = Spawn("sleep 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	errGaveUp := errors.New("gave up")
+	err = child.ExpectTimeoutSwitch(100*time.Millisecond,
+		Case{Literal("never printed"), nil},
+		Case{Timeout, func(m []string) error {
+			return errGaveUp
+		}},
+	)
+	if err != errGaveUp {
+		t.Fatalf("Expected the Timeout case to run, got %v", err)
+	}
+}
+
+func TestTimeoutKeepsOutput(t *testing.T) {
+	t.Logf("Testing a timed out Expect leaves the output unread...")
+	child, err := Spawn(`sh -c 'echo first; sleep 0.5; echo second'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.ExpectTimeout("second", 100*time.Millisecond); err == nil {
+		t.Fatal("Expected Expect to time out")
+	}
+	line, err := child.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "first\r" {
+		t.Fatalf("expected 'first\\r', got %q", line)
+	}
+	if err = child.ExpectTimeout("second", 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSessionTimeout(t *testing.T) {
+	t.Logf("Testing the session wide default timeout and per-call options...")
+	child, err := Spawn(`sh -c 'sleep 0.5; echo late'`, WithTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+
+	start := time.Now()
+	if err = child.Expect("late"); err == nil {
+		t.Fatal("Expected Expect to time out with the session default")
+	}
+	if _, err = child.ReadLine(); err == nil {
+		t.Fatal("Expected ReadLine to time out with the session default")
+	}
+	if _, err = child.ExpectRegexFind(`l(a)te`); err == nil {
+		t.Fatal("Expected ExpectRegexFind to time out with the session default")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Timed out calls took %v", elapsed)
+	}
+
+	if err = child.Expect("late", WithTimeout(5*time.Second)); err != nil {
+		t.Fatalf("A per-call timeout should override the default: %v", err)
+	}
+
+	child.SetTimeout(50 * time.Millisecond)
+	if child.Timeout() != 50*time.Millisecond {
+		t.Fatalf("Unexpected timeout %v", child.Timeout())
+	}
+	child, err = Spawn(`sh -c 'sleep 0.2; echo patient'`, WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("patient", NoTimeout()); err != nil {
+		t.Fatalf("NoTimeout should disable the default: %v", err)
+	}
+}
+
+func TestTypedErrors(t *testing.T) {
+	t.Logf("Testing TimeoutError, EOFError and NoMatchError...")
+	child, err := Spawn(`sh -c 'printf partial; sleep 5'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = child.Expect("never", WithTimeout(200*time.Millisecond))
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected a TimeoutError, got %v", err)
+	}
+	if timeoutErr.Pattern != "never" || string(timeoutErr.Output) != "partial" {
+		t.Fatalf("Unexpected TimeoutError contents %q %q", timeoutErr.Pattern, timeoutErr.Output)
+	}
+	child.Close()
+
+	child, err = Spawn(`sh -c 'echo bye; exit 3'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = child.Expect("never")
+	var eofErr *EOFError
+	if !errors.As(err, &eofErr) || !errors.Is(err, ErrEOF) {
+		t.Fatalf("Expected an EOFError, got %v", err)
+	}
+	if eofErr.ExitStatus != 3 {
+		t.Fatalf("Expected exit status 3, got %d", eofErr.ExitStatus)
+	}
+
+	child, err = Spawn("echo no numbers here")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = child.ExpectRegexFind(`\d+`)
+	var noMatchErr *NoMatchError
+	if !errors.As(err, &noMatchErr) || !errors.Is(err, ErrEOF) {
+		t.Fatalf("Expected a NoMatchError caused by EOF, got %v", err)
+	}
+	if noMatchErr.Pattern != `\d+` {
+		t.Fatalf("Unexpected pattern %q", noMatchErr.Pattern)
+	}
+}
+
+func TestBeforeAfterMatch(t *testing.T) {
+	t.Logf("Testing Before, After and Match...")
+	child, err := Spawn(`sh -c 'echo "$ "; echo "total 42"; echo "$ "; echo "code 7"; echo "size 24x80"'`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("$ "); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("$ "); err != nil {
+		t.Fatal(err)
+	}
+	if child.Before() != "\r\ntotal 42\r\n" || child.After() != "$ " {
+		t.Fatalf("Unexpected Before %q / After %q", child.Before(), child.After())
+	}
+	if _, err = child.ExpectRegexFind(`code (\d+)`); err != nil {
+		t.Fatal(err)
+	}
+	if child.Before() != "\r\n" || child.After() != "code 7" {
+		t.Fatalf("Unexpected Before %q / After %q", child.Before(), child.After())
+	}
+	if m := child.Match(); len(m) != 2 || m[1] != "7" {
+		t.Fatalf("Unexpected Match %q", m)
+	}
+	if ok, err := child.ExpectRegex(`size (\d+)x(\d+)`); !ok || err != nil {
+		t.Fatalf("Expected the size to match, got %v, %v", ok, err)
+	}
+	if m := child.Match(); len(m) != 3 || m[0] != "size 24x80" || m[1] != "24" || m[2] != "80" {
+		t.Fatalf("Unexpected Match %q", m)
+	}
+}
+
+func TestSpawnArgs(t *testing.T) {
+	t.Logf("Testing SpawnArgs passes arguments untouched...")
+	awkward := `it's a "quoted" $argument`
+	child, err := SpawnArgs("sh", "-c", `echo "[$1]"`, "sh", awkward)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := child.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line != "["+awkward+"]\r" {
+		t.Fatalf("Unexpected output %q", line)
+	}
+}
+
+func TestSpawnWithOptions(t *testing.T) {
+	t.Logf("Testing SpawnWithOptions...")
+	dir, err := ioutil.TempDir("", "gexpect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", `echo "$FOO|$TERM|$(pwd)|$(stty size)"`},
+		Dir:     dir,
+		Env:     []string{"FOO=bar", "PATH=" + os.Getenv("PATH")},
+		EnvMode: EnvReplace,
+		Term:    "vt100",
+		Rows:    30,
+		Cols:    100,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := child.ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("bar|vt100|%s|30 100\r", dir)
+	if line != want {
+		t.Fatalf("expected %q, got %q", want, line)
+	}
+
+	os.Setenv("GEXPECT_INHERITED", "yes")
+	defer os.Unsetenv("GEXPECT_INHERITED")
+	child, err = SpawnWithOptions(SpawnOptions{
+		Cmd: exec.Command("sh", "-c", `echo "$GEXPECT_INHERITED $FOO"`),
+		Env: []string{"FOO=merged"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("yes merged"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetWinSize(t *testing.T) {
+	t.Logf("Testing SetWinSize and GetWinSize...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", `trap 'echo "resized to $(stty size)"' WINCH; echo ready; while :; do sleep 0.05; done`},
+		Rows:    24,
+		Cols:    80,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+
+	rows, cols, err := child.GetWinSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 24 || cols != 80 {
+		t.Fatalf("Expected an initial size of 24x80, got %dx%d", rows, cols)
+	}
+	if err = child.Expect("ready"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SetWinSize(20, 70); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("resized to 20 70"); err != nil {
+		t.Fatal(err)
+	}
+	rows, cols, err = child.GetWinSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows != 20 || cols != 70 {
+		t.Fatalf("Expected a size of 20x70, got %dx%d", rows, cols)
+	}
+}
+
+func TestTerminate(t *testing.T) {
+	t.Logf("Testing Terminate escalates to SIGKILL...")
+	child, err := Spawn(`sh -c 'trap "" HUP TERM; echo ready; sleep 10'`, WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("ready"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = child.Terminate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Terminate took %v", elapsed)
+	}
+	if sig, ok := child.Signaled(); !ok || sig != syscall.SIGKILL {
+		t.Fatalf("Expected the child to be killed by SIGKILL, got %v %v", sig, ok)
+	}
+
+	child, err = Spawn("cat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Terminate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sig, ok := child.Signaled(); !ok || sig != syscall.SIGHUP {
+		t.Fatalf("Expected the child to exit on SIGHUP, got %v %v", sig, ok)
+	}
+	if child.ExitStatus() != -1 {
+		t.Fatalf("Expected no exit status for a signalled child, got %d", child.ExitStatus())
+	}
+}
+
+func TestExitStatusAndSignal(t *testing.T) {
+	t.Logf("Testing ExitStatus and Signal...")
+	child, err := Spawn(`sh -c 'trap "echo got INT; exit 4" INT; echo ready; while :; do sleep 0.05; done'`, WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.ExitStatus() != -1 {
+		t.Fatalf("Expected no exit status while running, got %d", child.ExitStatus())
+	}
+	if err = child.Expect("ready"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Signal(syscall.SIGINT); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("got INT"); err != nil {
+		t.Fatal(err)
+	}
+	child.Wait()
+	if child.ExitStatus() != 4 {
+		t.Fatalf("Expected exit status 4, got %d", child.ExitStatus())
+	}
+	if _, ok := child.Signaled(); ok {
+		t.Fatal("Child exited normally but was reported as signalled")
+	}
+}
+
+func TestLineTerminator(t *testing.T) {
+	t.Logf("Testing SetLineTerminator...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:           []string{"sh", "-c", `read a; read b; echo "got $a,$b"`},
+		LineTerminator: "\n",
+		Timeout:        5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if got := child.LineTerminator(); got != "\n" {
+		t.Fatalf("Expected the terminator from SpawnOptions, got %q", got)
+	}
+	child.SendLine("one")
+	child.SendLine("two")
+	if err = child.Expect("got one,two"); err != nil {
+		t.Fatal(err)
+	}
+	child.SetLineTerminator("")
+	if got := child.LineTerminator(); got != "\r\n" {
+		t.Fatalf("Expected the default terminator, got %q", got)
+	}
+}
+
+func TestSendDelay(t *testing.T) {
+	t.Logf("Testing SetSendDelay...")
+	child, err := Spawn("cat", WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	child.SetSendDelay(20*time.Millisecond, 10*time.Millisecond)
+	start := time.Now()
+	if err = child.SendLine("slow"); err != nil {
+		t.Fatal(err)
+	}
+	// The CR LF goes as one character.
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Fatalf("Expected four pauses of at least 20ms, took %v", elapsed)
+	}
+	// The terminal echoes the line and cat prints it again.
+	for i := 0; i < 2; i++ {
+		if err = child.Expect("slow"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Key is a special key of the keyboard, for SendKeys.
+type Key int
+
+const (
+	KeyUp Key = iota
+	KeyDown
+	KeyRight
+	KeyLeft
+	KeyHome
+	KeyEnd
+	KeyInsert
+	KeyDelete
+	KeyPageUp
+	KeyPageDown
+	KeyF1
+	KeyF2
+	KeyF3
+	KeyF4
+	KeyF5
+	KeyF6
+	KeyF7
+	KeyF8
+	KeyF9
+	KeyF10
+	KeyF11
+	KeyF12
+	KeyTab
+	KeyBackspace
+	KeyEscape
+	KeyEnter
+)
+
+// xtermKeys are the sequences sent by xterm, which most terminals follow.
+var xtermKeys = map[Key]string{
+	KeyUp:        "\x1b[A",
+	KeyDown:      "\x1b[B",
+	KeyRight:     "\x1b[C",
+	KeyLeft:      "\x1b[D",
+	KeyHome:      "\x1b[H",
+	KeyEnd:       "\x1b[F",
+	KeyInsert:    "\x1b[2~",
+	KeyDelete:    "\x1b[3~",
+	KeyPageUp:    "\x1b[5~",
+	KeyPageDown:  "\x1b[6~",
+	KeyF1:        "\x1bOP",
+	KeyF2:        "\x1bOQ",
+	KeyF3:        "\x1bOR",
+	KeyF4:        "\x1bOS",
+	KeyF5:        "\x1b[15~",
+	KeyF6:        "\x1b[17~",
+	KeyF7:        "\x1b[18~",
+	KeyF8:        "\x1b[19~",
+	KeyF9:        "\x1b[20~",
+	KeyF10:       "\x1b[21~",
+	KeyF11:       "\x1b[23~",
+	KeyF12:       "\x1b[24~",
+	KeyTab:       "\t",
+	KeyBackspace: "\x7f",
+	KeyEscape:    "\x1b",
+	KeyEnter:     "\r",
+}
+
+// termKeys holds the sequences that differ from xterm's, by the prefix of
+// TERM.
+var termKeys = []struct {
+	prefix string
+	keys   map[Key]string
+}{
+	{"linux", map[Key]string{
+		KeyHome: "\x1b[1~",
+		KeyEnd:  "\x1b[4~",
+		KeyF1:   "\x1b[[A",
+		KeyF2:   "\x1b[[B",
+		KeyF3:   "\x1b[[C",
+		KeyF4:   "\x1b[[D",
+		KeyF5:   "\x1b[[E",
+	}},
+	{"rxvt", map[Key]string{
+		KeyHome: "\x1b[7~",
+		KeyEnd:  "\x1b[8~",
+		KeyF1:   "\x1b[11~",
+		KeyF2:   "\x1b[12~",
+		KeyF3:   "\x1b[13~",
+		KeyF4:   "\x1b[14~",
+	}},
+	{"screen", map[Key]string{
+		KeyHome: "\x1b[1~",
+		KeyEnd:  "\x1b[4~",
+	}},
+	{"tmux", map[Key]string{
+		KeyHome: "\x1b[1~",
+		KeyEnd:  "\x1b[4~",
+	}},
+	{"vt", map[Key]string{
+		KeyBackspace: "\b",
+	}},
+}
+
+// keySequence returns what the terminal named term sends for k. appCursor
+// selects the sequences of the cursor keys application mode (DECCKM).
+func keySequence(term string, k Key, appCursor bool) (string, error) {
+	seq, ok := xtermKeys[k]
+	if !ok {
+		return "", fmt.Errorf("gexpect: unknown key %d", k)
+	}
+	for _, t := range termKeys {
+		if strings.HasPrefix(term, t.prefix) {
+			if s, ok := t.keys[k]; ok {
+				seq = s
+			}
+			break
+		}
+	}
+	if appCursor && strings.HasPrefix(seq, "\x1b[") && len(seq) == 3 {
+		// Up, Down, Right, Left and xterm's Home and End.
+		seq = "\x1bO" + seq[2:]
+	}
+	return seq, nil
+}
+
+// SendKeys sends the sequences of the given keys for the TERM of the child.
+// If the session has a Screen, the mode the program has put the cursor keys
+// in is taken into account.
+func (expect *ExpectSubprocess) SendKeys(keys ...Key) error {
+	appCursor := false
+	if expect.screen != nil {
+		appCursor = expect.screen.applicationCursorKeys()
+	}
+	term := expect.term()
+	var b strings.Builder
+	for _, k := range keys {
+		seq, err := keySequence(term, k, appCursor)
+		if err != nil {
+			return err
+		}
+		b.WriteString(seq)
+	}
+	_, err := expect.write([]byte(b.String()))
+	return err
+}
+
+// SendControl sends Ctrl and r pressed together, e.g. SendControl('c') for
+// Ctrl-C. r is a letter or one of @[\]^_?.
+func (expect *ExpectSubprocess) SendControl(r rune) error {
+	var c byte
+	switch {
+	case r >= 'a' && r <= 'z':
+		c = byte(r - 'a' + 1)
+	case r >= '@' && r <= '_':
+		c = byte(r - '@')
+	case r == '?':
+		c = 0x7f
+	default:
+		return fmt.Errorf("gexpect: no control character for %q", r)
+	}
+	_, err := expect.write([]byte{c})
+	return err
+}
+
+// SendEOF sends Ctrl-D, which ends the input of a program reading the
+// terminal when it is typed at the start of a line.
+func (expect *ExpectSubprocess) SendEOF() error {
+	return expect.SendControl('d')
+}
+
+// SendInterrupt sends Ctrl-C, which the terminal turns into SIGINT for the
+// foreground job.
+func (expect *ExpectSubprocess) SendInterrupt() error {
+	return expect.SendControl('c')
+}
@@ -0,0 +1,99 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeySequence(t *testing.T) {
+	t.Logf("Testing key sequences...")
+	tests := []struct {
+		term      string
+		key       Key
+		appCursor bool
+		want      string
+	}{
+		{"xterm-256color", KeyUp, false, "\x1b[A"},
+		{"xterm-256color", KeyUp, true, "\x1bOA"},
+		{"xterm", KeyHome, true, "\x1bOH"},
+		{"xterm", KeyF12, false, "\x1b[24~"},
+		{"linux", KeyF1, false, "\x1b[[A"},
+		{"linux", KeyHome, true, "\x1b[1~"},
+		{"screen-256color", KeyEnd, false, "\x1b[4~"},
+		{"rxvt-unicode", KeyF2, false, "\x1b[12~"},
+		{"vt100", KeyBackspace, false, "\b"},
+		{"", KeyPageDown, false, "\x1b[6~"},
+	}
+	for _, test := range tests {
+		got, err := keySequence(test.term, test.key, test.appCursor)
+		if err != nil || got != test.want {
+			t.Errorf("keySequence(%q, %d, %v) = %q, %v; want %q", test.term, test.key, test.appCursor, got, err, test.want)
+		}
+	}
+	if _, err := keySequence("xterm", Key(-1), false); err == nil {
+		t.Errorf("Expected an error for an unknown key")
+	}
+}
+
+func TestSendKeys(t *testing.T) {
+	t.Logf("Testing SendKeys and SendControl...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", "stty raw -echo; echo ready; head -c 6 | od -An -tx1"},
+		Term:    "xterm",
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if err = child.Expect("ready"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SendKeys(KeyUp, KeyTab); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SendControl('a'); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SendControl('['); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("1b 5b 41 09 01 1b"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SendControl('!'); err == nil {
+		t.Fatalf("Expected an error for Ctrl-!")
+	}
+}
+
+func TestSendInterruptAndEOF(t *testing.T) {
+	t.Logf("Testing SendInterrupt and SendEOF...")
+	child, err := Spawn(`sh -c 'trap "echo interrupted" INT; echo ready; while :; do sleep 0.05; done'`, WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if err = child.Expect("ready"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.SendInterrupt(); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("interrupted"); err != nil {
+		t.Fatal(err)
+	}
+
+	child, err = Spawn("cat", WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if err = child.SendEOF(); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Wait(); err != nil {
+		t.Fatalf("Expected cat to exit at the end of its input, got %v", err)
+	}
+}
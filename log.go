@@ -0,0 +1,163 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Directions of the traffic passed to loggers.
+const (
+	dirRecv = "recv"
+	dirSend = "send"
+)
+
+type sessionLogger interface {
+	log(t time.Time, dir string, data []byte, pattern string)
+}
+
+// transcript passes everything read from and written to the child on to the
+// loggers of a session, together with the pattern being waited for. Secrets
+// and output matching the redaction list are masked first.
+type transcript struct {
+	mu       sync.Mutex
+	loggers  []sessionLogger
+	pattern  string
+	secrets  [][]byte
+	patterns []*regexp.Regexp
+	held     []byte // output that may be the start of a secret
+}
+
+func (t *transcript) add(l sessionLogger) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.loggers = append(t.loggers, l)
+}
+
+func (t *transcript) record(dir string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.loggers) == 0 {
+		return
+	}
+	if dir == dirSend {
+		t.emit(dirRecv, t.held)
+		t.held = nil
+		t.emit(dirSend, t.redact(data))
+	} else {
+		t.emit(dirRecv, t.redactStream(data))
+	}
+}
+
+// flush passes on any output held back by redactStream once the output ends.
+func (t *transcript) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emit(dirRecv, t.held)
+	t.held = nil
+}
+
+func (t *transcript) emit(dir string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, l := range t.loggers {
+		l.log(now, dir, data, t.pattern)
+	}
+}
+
+// waitFor records that the session is waiting for pattern until the returned
+// function is called.
+func (t *transcript) waitFor(pattern string) func() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	previous := t.pattern
+	t.pattern = pattern
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.pattern = previous
+	}
+}
+
+type writerLogger struct {
+	w io.Writer
+}
+
+func (l writerLogger) log(t time.Time, dir string, data []byte, pattern string) {
+	l.w.Write(data)
+}
+
+// prefixedLogger starts every line, and every change of direction, with the
+// prefix of the direction.
+type prefixedLogger struct {
+	w        io.Writer
+	prefixes map[string]string
+	dir      string
+	midLine  bool
+}
+
+func (l *prefixedLogger) log(t time.Time, dir string, data []byte, pattern string) {
+	var b bytes.Buffer
+	for len(data) > 0 {
+		if l.midLine && dir != l.dir {
+			b.WriteByte('\n')
+			l.midLine = false
+		}
+		if !l.midLine {
+			b.WriteString(l.prefixes[dir])
+		}
+		l.dir = dir
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		b.Write(line)
+		l.midLine = line[len(line)-1] != '\n'
+		data = data[len(line):]
+	}
+	l.w.Write(b.Bytes())
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) log(t time.Time, dir string, data []byte, pattern string) {
+	attrs := []slog.Attr{slog.String("dir", dir), slog.String("data", string(data))}
+	if pattern != "" {
+		attrs = append(attrs, slog.String("pattern", pattern))
+	}
+	r := slog.NewRecord(t, slog.LevelDebug, "gexpect "+dir, 0)
+	r.AddAttrs(attrs...)
+	if l.l.Enabled(context.Background(), r.Level) {
+		l.l.Handler().Handle(context.Background(), r)
+	}
+}
+
+// LogTo copies everything read from and written to the child, as it is, to
+// w. Like the other loggers it can be added several times to log to more than
+// one place.
+func (expect *ExpectSubprocess) LogTo(w io.Writer) {
+	expect.log.add(writerLogger{w})
+}
+
+// LogToPrefixed writes the traffic with the child to w line by line, each
+// line starting with sendPrefix or recvPrefix depending on its direction.
+func (expect *ExpectSubprocess) LogToPrefixed(w io.Writer, sendPrefix, recvPrefix string) {
+	expect.log.add(&prefixedLogger{w: w, prefixes: map[string]string{dirSend: sendPrefix, dirRecv: recvPrefix}})
+}
+
+// SetLogger logs every read from and write to the child to l, as debug
+// records with the attributes "dir" ("send" or "recv"), "data" and, while an
+// Expect method is waiting, "pattern". It is added to any other loggers.
+func (expect *ExpectSubprocess) SetLogger(l *slog.Logger) {
+	expect.log.add(slogLogger{l})
+}
@@ -0,0 +1,82 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that is safe to read while the session is
+// still logging to it.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestLogTo(t *testing.T) {
+	t.Logf("Testing LogTo and LogToPrefixed...")
+	child, err := Spawn(`sh -c "read line; echo got $line"`, WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	var raw, prefixed syncBuffer
+	child.LogTo(&raw)
+	child.LogToPrefixed(&prefixed, "> ", "< ")
+
+	if err = child.Send("hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("got hello"); err != nil {
+		t.Fatal(err)
+	}
+	if got := raw.String(); !strings.HasPrefix(got, "hello\nhello\r\ngot hello") {
+		t.Fatalf("Expected the input, its echo and the output, got %q", got)
+	}
+	if got := prefixed.String(); !strings.HasPrefix(got, "> hello\n< hello\r\n< got hello") {
+		t.Fatalf("Expected prefixed lines, got %q", got)
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	t.Logf("Testing SetLogger...")
+	child, err := Spawn("echo ready", WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	var out syncBuffer
+	child.SetLogger(slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	if err = child.Expect("ready"); err != nil {
+		t.Fatal(err)
+	}
+	var record struct {
+		Msg, Dir, Data, Pattern string
+	}
+	line, _, _ := strings.Cut(out.String(), "\n")
+	if err = json.Unmarshal([]byte(line), &record); err != nil {
+		t.Fatalf("Expected a JSON record, got %q: %v", line, err)
+	}
+	if record.Dir != "recv" || !strings.Contains(record.Data, "ready") || record.Pattern != "ready" {
+		t.Fatalf("Unexpected record %+v", record)
+	}
+}
@@ -0,0 +1,132 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// MultiCase pairs a session with a pattern for ExpectMulti. Pattern may be
+// EOF, matching the end of the session's output.
+type MultiCase struct {
+	Session *ExpectSubprocess
+	Pattern Pattern
+}
+
+// ExpectMulti waits until one of the cases matches the output of its session,
+// like expect -i in Tcl, and returns the index of that case and the
+// submatches. Only the matching session consumes its output, up to the end of
+// the match, and has its Before and Match set; the output of the others is
+// left for later calls. Of several patterns of one session the earliest in
+// its output wins, with ties going to the lower index.
+//
+// If the output of a session ends without a match and it has no EOF case,
+// ExpectMulti returns the index of its first case and an *EOFError. It gives
+// up with a *TimeoutError after the shortest of the session default
+// timeouts, or when ctx is done.
+func ExpectMulti(ctx context.Context, cases ...MultiCase) (int, []string, error) {
+	if len(cases) == 0 {
+		return -1, nil, ErrEmptySearch
+	}
+	var sessions []*ExpectSubprocess
+	var all []Pattern
+	indexes := map[*ExpectSubprocess][]int{}
+	for i, c := range cases {
+		if c.Session == nil || c.Pattern == nil || c.Pattern.String() == "" || c.Pattern == Timeout {
+			return -1, nil, ErrEmptySearch
+		}
+		if indexes[c.Session] == nil {
+			sessions = append(sessions, c.Session)
+		}
+		indexes[c.Session] = append(indexes[c.Session], i)
+		all = append(all, c.Pattern)
+	}
+
+	// The session with the shortest timeout reports running out of time.
+	limiter := sessions[0]
+	timeout := time.Duration(0)
+	for _, s := range sessions {
+		if t := s.Timeout(); t > 0 && (timeout == 0 || t < timeout) {
+			limiter, timeout = s, t
+		}
+	}
+	var waitCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		waitCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		waitCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	type result struct {
+		index  int
+		match  []string
+		before string
+		ended  bool
+	}
+	// The first session to match claims the win, under the lock of its
+	// buffer, before consuming anything; the others then give up without
+	// consuming.
+	var claimed atomic.Bool
+	results := make(chan result, len(sessions))
+	for _, s := range sessions {
+		s, idx := s, indexes[s]
+		var patterns []Pattern
+		for _, i := range idx {
+			patterns = append(patterns, cases[i].Pattern)
+		}
+		go func() {
+			done := s.log.waitFor(fmt.Sprint(patterns))
+			defer done()
+			r := result{index: -1}
+			s.buf.wait(waitCtx, func(data []byte, atEOF bool) (int, bool) {
+				index, eofIndex, loc := -1, -1, []int(nil)
+				for _, i := range idx {
+					if cases[i].Pattern == EOF {
+						if eofIndex < 0 {
+							eofIndex = i
+						}
+					} else if l := cases[i].Pattern.find(data); l != nil && (loc == nil || l[0] < loc[0]) {
+						index, loc = i, l
+					}
+				}
+				if loc == nil && !atEOF {
+					return 0, false
+				}
+				if !claimed.CompareAndSwap(false, true) {
+					return 0, true
+				}
+				switch {
+				case loc != nil:
+					r.index, r.match, r.before = index, submatches(data, loc), string(data[:loc[0]])
+					return loc[1], true
+				case eofIndex >= 0:
+					r.index = eofIndex
+				default:
+					r.index, r.ended = idx[0], true
+				}
+				return 0, true
+			})
+			results <- r
+		}()
+	}
+
+	for range sessions {
+		r := <-results
+		if r.index < 0 {
+			continue
+		}
+		s := cases[r.index].Session
+		if r.ended {
+			return r.index, nil, s.readError("ExpectMulti", cases[r.index].Pattern.String(), s.buf.error())
+		}
+		if r.match != nil {
+			s.setMatch(r.before, r.match)
+		}
+		return r.index, r.match, nil
+	}
+	return -1, nil, limiter.interrupted(ctx, timeout, "ExpectMulti", fmt.Sprint(all))
+}
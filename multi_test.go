@@ -0,0 +1,94 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestExpectMulti(t *testing.T) {
+	t.Logf("Testing ExpectMulti...")
+	server, err := SpawnArgs("sh", "-c", "echo starting; sleep 0.3; echo listening on 8080; sleep 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	client, err := SpawnArgs("sh", "-c", "echo connecting; sleep 5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Expect("connecting"); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Expect("starting"); err != nil {
+		t.Fatal(err)
+	}
+	client.SendLine("hello")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	index, match, err := ExpectMulti(ctx,
+		MultiCase{client, Literal("refused")},
+		MultiCase{server, Regexp(regexp.MustCompile(`listening on (\d+)`))},
+		MultiCase{client, EOF},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 1 || len(match) != 2 || match[1] != "8080" {
+		t.Fatalf("Expected the server to match with port 8080, got %d, %q", index, match)
+	}
+	if server.After() != "listening on 8080" {
+		t.Fatalf("Expected the server's match to be set, got %q", server.After())
+	}
+	// The echo of "hello" was not consumed from the client.
+	if err := client.Expect("hello", WithTimeout(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpectMultiEOF(t *testing.T) {
+	t.Logf("Testing ExpectMulti at the end of the output...")
+	quiet, err := SpawnArgs("sleep", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer quiet.Close()
+	done, err := SpawnArgs("echo", "bye")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done.Close()
+
+	ctx := context.Background()
+	index, _, err := ExpectMulti(ctx, MultiCase{quiet, Literal("never")}, MultiCase{done, EOF})
+	if err != nil || index != 1 {
+		t.Fatalf("Expected the EOF case, got %d, %v", index, err)
+	}
+	// Without an EOF case the end of the output is an error.
+	index, _, err = ExpectMulti(ctx, MultiCase{quiet, Literal("never")}, MultiCase{done, Literal("never")})
+	if !errors.Is(err, ErrEOF) || index != 1 {
+		t.Fatalf("Expected an EOF error for case 1, got %d, %v", index, err)
+	}
+
+	// The session timeout applies.
+	quiet.SetTimeout(100 * time.Millisecond)
+	var timeoutErr *TimeoutError
+	_, _, err = ExpectMulti(ctx, MultiCase{quiet, Literal("never")})
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != 100*time.Millisecond {
+		t.Fatalf("Expected a TimeoutError after 100ms, got %v", err)
+	}
+	quiet.SetTimeout(0)
+
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, _, err = ExpectMulti(ctx, MultiCase{quiet, Literal("never")})
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline to pass, got %v", err)
+	}
+}
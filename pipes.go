@@ -0,0 +1,214 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// ErrNoPipes is returned by ExpectStderr and ReadLineStderr for a session that
+// was not started with SpawnOptions.Pipes.
+var ErrNoPipes = errors.New("gexpect: session does not use pipes")
+
+// pipeBacklog is how much output a pipe session keeps before it is first
+// read; older output is dropped.
+const pipeBacklog = 64 << 10
+
+// pipeBuffer is an in-memory pipe whose writes never block, so that a
+// stream nobody reads cannot stall the child. Until the first read it keeps
+// only the last pipeBacklog bytes, so that a stream nobody reads does not
+// grow without limit either.
+type pipeBuffer struct {
+	mu    sync.Mutex
+	cond  sync.Cond
+	b     bytes.Buffer
+	err   error
+	limit int // the most bytes kept, if not zero
+}
+
+func newPipeBuffer() *pipeBuffer {
+	p := &pipeBuffer{limit: pipeBacklog}
+	p.cond.L = &p.mu
+	return p
+}
+
+func (p *pipeBuffer) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.limit = 0
+	for p.b.Len() == 0 && p.err == nil {
+		p.cond.Wait()
+	}
+	if p.err == os.ErrClosed || p.b.Len() == 0 {
+		return 0, p.err
+	}
+	return p.b.Read(b)
+}
+
+func (p *pipeBuffer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return 0, p.err
+	}
+	p.cond.Broadcast()
+	n, err := p.b.Write(b)
+	if p.limit > 0 && p.b.Len() > p.limit {
+		p.b.Next(p.b.Len() - p.limit)
+	}
+	return n, err
+}
+
+// closeWithError makes reads return err once the buffered data is read, or
+// at once if err is os.ErrClosed. Only the first error is kept.
+func (p *pipeBuffer) closeWithError(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil || err == os.ErrClosed {
+		p.err = err
+	}
+	p.cond.Broadcast()
+}
+
+// pipeConn is what a pipe session reads from and writes to: the output of
+// one or both streams, and the child's stdin.
+type pipeConn struct {
+	r     *pipeBuffer
+	stdin *os.File
+	owner bool // closing the conn also closes stdin
+}
+
+func (c *pipeConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func (c *pipeConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+func (c *pipeConn) Close() error {
+	c.r.closeWithError(os.ErrClosed)
+	if c.owner {
+		return c.stdin.Close()
+	}
+	return nil
+}
+
+// startPipes starts the child on pipes. The session reads the merged output;
+// Stdout and Stderr get their own sessions.
+func startPipes(expect *ExpectSubprocess) error {
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		return err
+	}
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		stdoutR.Close()
+		stdoutW.Close()
+		return err
+	}
+	expect.Cmd.Stdin, expect.Cmd.Stdout, expect.Cmd.Stderr = stdinR, stdoutW, stderrW
+	// Like under a pty, make the child a process group leader so that
+	// Terminate and Signal reach its children too.
+	if expect.Cmd.SysProcAttr == nil {
+		expect.Cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	expect.Cmd.SysProcAttr.Setpgid = true
+	err = expect.Cmd.Start()
+	stdinR.Close()
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		stderrR.Close()
+		return err
+	}
+
+	merged, stdout, stderr := newPipeBuffer(), newPipeBuffer(), newPipeBuffer()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go copyStream(&wg, stdoutR, stdout, merged)
+	go copyStream(&wg, stderrR, stderr, merged)
+	go func() {
+		wg.Wait()
+		merged.closeWithError(io.EOF)
+	}()
+
+	expect.buf.f = &pipeConn{r: merged, stdin: stdinW, owner: true}
+	expect.stdout = expect.pipeView(&pipeConn{r: stdout, stdin: stdinW})
+	expect.stderr = expect.pipeView(&pipeConn{r: stderr, stdin: stdinW})
+	return nil
+}
+
+// copyStream copies the output of one stream of the child to its own buffer
+// and to the merged one.
+func copyStream(wg *sync.WaitGroup, src *os.File, own, merged *pipeBuffer) {
+	defer wg.Done()
+	defer src.Close()
+	chunk := make([]byte, 4096)
+	for {
+		n, err := src.Read(chunk)
+		own.Write(chunk[:n])
+		merged.Write(chunk[:n])
+		if err != nil {
+			own.closeWithError(io.EOF)
+			return
+		}
+	}
+}
+
+// pipeView returns a session reading one stream of a pipe session, with the
+// same settings.
+func (expect *ExpectSubprocess) pipeView(conn *pipeConn) *ExpectSubprocess {
+	view := newSession(conn)
+	view.SetTimeout(expect.Timeout())
+	view.SetLineTerminator(expect.LineTerminator())
+	return view
+}
+
+// Stdout returns a session reading only the standard output of a child
+// started with SpawnOptions.Pipes, or nil. It has its own buffer, so what is
+// read from it is still there for the session itself, which reads the
+// merged output. Until a session of a pipe child is first read from, it
+// keeps only the last 64 KiB of its output. Send writes to the child's stdin; the process is controlled
+// with the methods of the session itself.
+func (expect *ExpectSubprocess) Stdout() *ExpectSubprocess {
+	return expect.stdout
+}
+
+// Stderr is like Stdout for the standard error.
+func (expect *ExpectSubprocess) Stderr() *ExpectSubprocess {
+	return expect.stderr
+}
+
+// ExpectStderr is Expect on the standard error of a child started with
+// SpawnOptions.Pipes.
+func (expect *ExpectSubprocess) ExpectStderr(searchString string, opts ...Option) error {
+	if expect.stderr == nil {
+		return ErrNoPipes
+	}
+	return expect.stderr.Expect(searchString, opts...)
+}
+
+// ReadLineStderr is ReadLine on the standard error of a child started with
+// SpawnOptions.Pipes.
+func (expect *ExpectSubprocess) ReadLineStderr(opts ...Option) (string, error) {
+	if expect.stderr == nil {
+		return "", ErrNoPipes
+	}
+	return expect.stderr.ReadLine(opts...)
+}
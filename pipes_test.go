@@ -0,0 +1,165 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSpawnPipes(t *testing.T) {
+	t.Logf("Testing SpawnOptions.Pipes...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", `if [ -t 1 ]; then echo tty; else echo notty; fi; echo oops >&2; read line; echo "got $line"`},
+		Pipes:   true,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+
+	if err = child.ExpectStderr("oops\n"); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := child.Stdout().ReadLine(); err != nil || line != "notty" {
+		t.Fatalf("Expected notty on stdout, got %q, %v", line, err)
+	}
+	if err = child.SendLine("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Stdout().Expect("got hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// The merged view has both streams, in no particular order.
+	if ok, err := child.ExpectRegex(`(?s)notty.*oops|oops.*notty`); !ok || err != nil {
+		t.Fatalf("Expected both streams in the merged output, got %v, %v", ok, err)
+	}
+	if _, err = child.ReadLineStderr(); !errors.Is(err, ErrEOF) {
+		t.Fatalf("Expected the end of stderr, got %v", err)
+	}
+	if _, _, err = child.GetWinSize(); err != ErrNoPty {
+		t.Fatalf("Expected ErrNoPty, got %v", err)
+	}
+}
+
+func TestSpawnPipesSignal(t *testing.T) {
+	t.Logf("Testing Signal and Terminate without a pty...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", "echo ready; while :; do sleep 0.05; done"},
+		Pipes:   true,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("ready"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	child.Wait()
+	if sig, ok := child.Signaled(); !ok || sig != syscall.SIGTERM {
+		t.Fatalf("Expected the child to be terminated, got %v, %v", sig, ok)
+	}
+	if err = child.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	child, err = Spawn("cat", WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if err = child.ExpectStderr("x"); err != ErrNoPipes {
+		t.Fatalf("Expected ErrNoPipes, got %v", err)
+	}
+}
+
+func TestSpawnPipesCloseKillsGroup(t *testing.T) {
+	t.Logf("Testing Close kills the background jobs of a pipe session...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", `sleep 30 & echo $!; wait`},
+		Pipes:   true,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := child.Stdout().ReadLine()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(line)
+	if err != nil {
+		t.Fatalf("Expected the pid of sleep, got %q", line)
+	}
+	child.Close()
+
+	// The grandchild held the pipes open: once it is gone the output of
+	// the views ends.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := child.Stdout().WaitContext(ctx); err != nil {
+		t.Fatalf("Expected the stdout view to end, got %v", err)
+	}
+	if err := child.Stderr().WaitContext(ctx); err != nil {
+		t.Fatalf("Expected the stderr view to end, got %v", err)
+	}
+	// The pipes close before the process has quite finished exiting, and a
+	// killed process that nobody reaped yet is a zombie.
+	for {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil || strings.Contains(string(stat), ") Z ") {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Expected sleep to be gone, got %s", stat)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSpawnPipesBacklog(t *testing.T) {
+	t.Logf("Testing a pipe session keeps a limited backlog until it is read...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", `head -c 200000 /dev/zero | tr '\0' x >&2; echo done`},
+		Pipes:   true,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if err = child.Stdout().Expect("done"); err != nil {
+		t.Fatal(err)
+	}
+	// Neither the session itself nor its stderr has been read.
+	for _, view := range []*ExpectSubprocess{child, child.Stderr()} {
+		p := view.buf.f.(*pipeConn).r
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			p.mu.Lock()
+			n, ended := p.b.Len(), p.err != nil
+			p.mu.Unlock()
+			if ended || time.Now().After(deadline) {
+				if n != pipeBacklog {
+					t.Fatalf("Expected %d bytes kept, got %d", pipeBacklog, n)
+				}
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bytes"
+	"regexp"
+)
+
+// redactedMask replaces secrets and redacted output.
+const redactedMask = "********"
+
+// SendSecret sends secret like Send, but masks it wherever the session reports
+// what was sent or received: in loggers and recordings, in Before, After and
+// Match, in the output returned by Collect and ExpectRegexFindWithOutput, and
+// in errors. That includes the child echoing it back.
+func (expect *ExpectSubprocess) SendSecret(secret string) error {
+	expect.log.addSecret(secret)
+	return expect.Send(secret)
+}
+
+// SendPasswordLine is SendLine for a password, which is masked as by
+// SendSecret.
+func (expect *ExpectSubprocess) SendPasswordLine(password string) error {
+	expect.log.addSecret(password)
+	return expect.SendLine(password)
+}
+
+// Redact masks output matching re wherever SendSecret masks a secret. The
+// loggers see the output a read at a time, so a match split across two reads
+// is missed there.
+func (expect *ExpectSubprocess) Redact(re *regexp.Regexp) {
+	expect.log.mu.Lock()
+	defer expect.log.mu.Unlock()
+	expect.log.patterns = append(expect.log.patterns, re)
+}
+
+func (t *transcript) addSecret(secret string) {
+	if secret == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.secrets = append(t.secrets, []byte(secret))
+}
+
+// redactOutput returns a masked copy of data.
+func (t *transcript) redactOutput(data []byte) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]byte{}, t.redact(data)...)
+}
+
+// redactString returns a masked copy of s.
+func (t *transcript) redactString(s string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.redact([]byte(s)))
+}
+
+// redact masks the secrets and the matches of the redaction list in data.
+// It does not modify data, but may return it. t.mu must be held.
+func (t *transcript) redact(data []byte) []byte {
+	for _, secret := range t.secrets {
+		if bytes.Contains(data, secret) {
+			data = bytes.ReplaceAll(data, secret, []byte(redactedMask))
+		}
+	}
+	for _, re := range t.patterns {
+		if re.Match(data) {
+			data = re.ReplaceAll(data, []byte(redactedMask))
+		}
+	}
+	return data
+}
+
+// redactStream masks the next part of the output. The end of it is held
+// back while it could be the start of a secret. t.mu must be held.
+func (t *transcript) redactStream(data []byte) []byte {
+	if len(t.held) > 0 {
+		data = append(t.held, data...)
+		t.held = nil
+	}
+	data = t.redact(data)
+	hold := 0
+	for _, secret := range t.secrets {
+		for n := len(secret) - 1; n > hold; n-- {
+			if bytes.HasSuffix(data, secret[:n]) {
+				hold = n
+				break
+			}
+		}
+	}
+	if hold > 0 {
+		t.held = append([]byte(nil), data[len(data)-hold:]...)
+		data = data[:len(data)-hold]
+	}
+	return data
+}
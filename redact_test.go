@@ -0,0 +1,96 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSendPasswordLine(t *testing.T) {
+	t.Logf("Testing SendPasswordLine...")
+	child, err := Spawn("cat", WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	var log syncBuffer
+	child.LogTo(&log)
+
+	child.Capture()
+	if err = child.SendPasswordLine("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	// The terminal echoes the password and cat prints it again.
+	if err = child.Expect("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = child.Expect("hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if child.After() != redactedMask || strings.Contains(child.Before(), "hunter2") {
+		t.Fatalf("Expected the match to be masked, got %q / %q", child.Before(), child.After())
+	}
+	if got := string(child.Collect()); strings.Contains(got, "hunter2") || !strings.Contains(got, redactedMask) {
+		t.Fatalf("Expected Collect to mask the password, got %q", got)
+	}
+	if got := log.String(); strings.Contains(got, "hunter2") || strings.Count(got, redactedMask) != 3 {
+		t.Fatalf("Expected the log to mask the password three times, got %q", got)
+	}
+
+	if err = child.SendSecret("hunter2\n"); err != nil {
+		t.Fatal(err)
+	}
+	var timeoutErr *TimeoutError
+	err = child.Expect("nothing", WithTimeout(200*time.Millisecond))
+	if !errors.As(err, &timeoutErr) || strings.Contains(string(timeoutErr.Output), "hunter2") {
+		t.Fatalf("Expected a TimeoutError without the password, got %v", err)
+	}
+	_, output, err := child.ExpectRegexFindWithOutput(`hunter(2)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(output, "hunter2") || strings.Contains(child.Before(), "hunter2") || strings.Contains(child.Match()[0], "hunter2") {
+		t.Fatalf("Expected the output and match to be masked, got %q, %q", output, child.Match())
+	}
+}
+
+func TestRedact(t *testing.T) {
+	t.Logf("Testing Redact...")
+	child, err := Spawn("echo token=abc123 ok", WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	var log syncBuffer
+	child.LogTo(&log)
+	child.Redact(regexp.MustCompile(`token=\w+`))
+
+	if err = child.Expect("ok"); err != nil {
+		t.Fatal(err)
+	}
+	if got := log.String(); !strings.HasPrefix(got, redactedMask+" ok") {
+		t.Fatalf("Expected the token to be masked, got %q", got)
+	}
+}
+
+func TestRedactStream(t *testing.T) {
+	t.Logf("Testing redaction of a secret split across reads...")
+	var log syncBuffer
+	tr := &transcript{}
+	tr.add(writerLogger{&log})
+	tr.addSecret("secret")
+	for _, chunk := range []string{"my se", "c", "ret is safe, ", "my sec"} {
+		tr.record(dirRecv, []byte(chunk))
+	}
+	if got := log.String(); got != "my "+redactedMask+" is safe, my " {
+		t.Fatalf("Unexpected log %q", got)
+	}
+	tr.flush()
+	if got := log.String(); !strings.HasSuffix(got, "my sec") {
+		t.Fatalf("Expected the held output to be flushed, got %q", got)
+	}
+}
@@ -0,0 +1,193 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrIncompleteInput is returned by Eval when the interpreter still asks for
+// more input after the whole of the code was sent. The input is abandoned
+// with Ctrl-C.
+var ErrIncompleteInput = errors.New("gexpect: interpreter expects more input")
+
+// REPLConfig describes an interactive interpreter for SpawnREPL.
+type REPLConfig struct {
+	// Args holds the interpreter and its arguments.
+	Args []string
+	// Env is added to the interpreter's environment.
+	Env []string
+	// Prompt matches the primary prompt, shown when the interpreter waits for
+	// a new statement. It should be anchored to the start of a line, with
+	// (?m)^, so that output resembling it mid-line is not taken for it.
+	Prompt *regexp.Regexp
+	// Continuation matches the prompt shown while a statement is incomplete,
+	// if the interpreter has one.
+	Continuation *regexp.Regexp
+	// Echo says that the interpreter's line editor echoes each line sent
+	// before its output; the echo is removed from what Eval returns.
+	// SpawnREPL turns the echo of the terminal itself off.
+	Echo bool
+	// BlankLineEndsBlock says that an empty line completes a block left open
+	// at the end of the code, as in Python.
+	BlankLineEndsBlock bool
+}
+
+// Presets for common interpreters. Copy one and append to Args to pass a
+// script, database or connection string.
+var (
+	Python = REPLConfig{
+		Args:               []string{"python3", "-i", "-q"},
+		Env:                []string{"PYTHONSTARTUP=", "PYTHON_BASIC_REPL=1"},
+		Prompt:             regexp.MustCompile(`(?m)^>>> `),
+		Continuation:       regexp.MustCompile(`(?m)^\.\.\. `),
+		Echo:               true,
+		BlankLineEndsBlock: true,
+	}
+	Node = REPLConfig{
+		Args:         []string{"node", "-i"},
+		Env:          []string{"NODE_NO_READLINE=1", "NODE_DISABLE_COLORS=1"},
+		Prompt:       regexp.MustCompile(`(?m)^> `),
+		Continuation: regexp.MustCompile(`(?m)^\.\.\. `),
+	}
+	IRB = REPLConfig{
+		Args:         []string{"irb", "--simple-prompt", "--nocolorize", "--nomultiline", "--nosingleline"},
+		Prompt:       regexp.MustCompile(`(?m)^>> `),
+		Continuation: regexp.MustCompile("(?m)^[?*\"'/`]> "),
+	}
+	SQLite = REPLConfig{
+		Args:         []string{"sqlite3", "-interactive"},
+		Prompt:       regexp.MustCompile(`(?m)^sqlite> `),
+		Continuation: regexp.MustCompile(`(?m)^ *\.\.\.> `),
+		Echo:         true,
+	}
+	Psql = REPLConfig{
+		Args:         []string{"psql", "-X", "-n", "-P", "pager=off", "-v", "PROMPT1=psql=> ", "-v", "PROMPT2=psql-> "},
+		Prompt:       regexp.MustCompile(`(?m)^psql=> `),
+		Continuation: regexp.MustCompile(`(?m)^psql-> `),
+	}
+)
+
+// REPL drives an interactive interpreter one piece of code at a time.
+type REPL struct {
+	*ExpectSubprocess
+	config REPLConfig
+	// prompts match the primary and continuation prompts, only at the end of
+	// the output so far: an interpreter waiting for input prints nothing
+	// after its prompt, whereas output that looks like one is followed by
+	// more.
+	prompts []Pattern
+}
+
+// SpawnREPL starts the interpreter described by config, with the echo of its
+// terminal off, and waits for its first prompt.
+func SpawnREPL(config REPLConfig, opts ...Option) (*REPL, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	expect, err := SpawnWithOptions(SpawnOptions{
+		Args:    config.Args,
+		Env:     config.Env,
+		Term:    "dumb",
+		Timeout: o.timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewREPL(expect, config)
+	if err == nil {
+		err = expect.setEcho(false)
+	}
+	if err == nil {
+		// Line editors look at the terminal afresh for each line, so go
+		// through an empty one for the setting to take effect.
+		_, err = r.Eval("")
+	}
+	if err != nil {
+		expect.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// NewREPL drives the interpreter running in expect, as described by config,
+// and waits for its first prompt. config.Args and config.Env are not used.
+// If the terminal echoes input too, config.Echo should be set.
+func NewREPL(expect *ExpectSubprocess, config REPLConfig) (*REPL, error) {
+	if config.Prompt == nil {
+		return nil, ErrEmptySearch
+	}
+	r := &REPL{ExpectSubprocess: expect, config: config}
+	for _, re := range []*regexp.Regexp{config.Prompt, config.Continuation} {
+		if re != nil {
+			r.prompts = append(r.prompts, Regexp(regexp.MustCompile(`(?:`+re.String()+`)\z`)))
+		}
+	}
+	if _, _, _, err := expect.ExpectAny(r.prompts[0]); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Eval sends code, which may span several lines, and returns the output
+// printed until the interpreter is ready for more, with line endings as "\n".
+// The lines are sent one at a time, each after the prompt for it.
+func (r *REPL) Eval(code string) (string, error) {
+	return r.EvalContext(context.Background(), code)
+}
+
+// EvalContext is Eval under ctx. The session timeout applies to each prompt.
+func (r *REPL) EvalContext(ctx context.Context, code string) (string, error) {
+	lines := strings.Split(strings.TrimRight(code, "\n"), "\n")
+	var output strings.Builder
+	blankSent := false
+	for i := 0; ; i++ {
+		var line string
+		switch {
+		case i < len(lines):
+			line = lines[i]
+		case r.config.BlankLineEndsBlock && !blankSent:
+			blankSent = true
+		default:
+			r.SendInterrupt()
+			r.ExpectAnyContext(ctx, r.prompts[0])
+			return unixLines(output.String()), ErrIncompleteInput
+		}
+		if err := r.Send(line + "\n"); err != nil {
+			return unixLines(output.String()), err
+		}
+		index, _, before, err := r.ExpectAnyContext(ctx, r.prompts...)
+		if err != nil {
+			return unixLines(output.String()), err
+		}
+		if r.config.Echo {
+			before = stripEcho(before, line)
+		}
+		output.WriteString(before)
+		if index == 0 && i >= len(lines)-1 {
+			return unixLines(output.String()), nil
+		}
+	}
+}
+
+func unixLines(output string) string {
+	return strings.ReplaceAll(output, "\r\n", "\n")
+}
+
+// stripEcho removes the echo of line from the start of output.
+func stripEcho(output, line string) string {
+	if !strings.HasPrefix(output, line) {
+		return output
+	}
+	rest := output[len(line):]
+	for _, eol := range []string{"\r\r\n", "\r\n", "\n"} {
+		if strings.HasPrefix(rest, eol) {
+			return rest[len(eol):]
+		}
+	}
+	return output
+}
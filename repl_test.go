@@ -0,0 +1,72 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func spawnREPL(t *testing.T, config REPLConfig) *REPL {
+	if _, err := exec.LookPath(config.Args[0]); err != nil {
+		t.Skipf("%s: %v", config.Args[0], err)
+	}
+	r, err := SpawnREPL(config, WithTimeout(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+type evalTest struct {
+	code, output string
+	err          error
+}
+
+func testEval(t *testing.T, r *REPL, tests []evalTest) {
+	for _, test := range tests {
+		output, err := r.Eval(test.code)
+		if err != test.err {
+			t.Fatalf("Eval(%q): expected error %v, got %v", test.code, test.err, err)
+		}
+		if output != test.output {
+			t.Errorf("Eval(%q) = %q, expected %q", test.code, output, test.output)
+		}
+	}
+}
+
+func TestREPLPython(t *testing.T) {
+	t.Logf("Testing REPL with python3...")
+	r := spawnREPL(t, Python)
+	testEval(t, r, []evalTest{
+		{"1 + 2", "3\n", nil},
+		{"print('>>> not a prompt')", ">>> not a prompt\n", nil},
+		{"x = 5", "", nil},
+		{"for i in range(x):\n    if i % 2:\n        print(i)", "1\n3\n", nil},
+		{"def f(n):\n    return n * 2\n\nf(21)", "42\n", nil},
+		{"(1,", "", ErrIncompleteInput},
+		{"x", "5\n", nil},
+	})
+}
+
+func TestREPLNode(t *testing.T) {
+	t.Logf("Testing REPL with node...")
+	r := spawnREPL(t, Node)
+	testEval(t, r, []evalTest{
+		{"1 + 2", "3\n", nil},
+		{"function f(n) {\n  return n * 2\n}", "undefined\n", nil},
+		{"f(21)", "42\n", nil},
+	})
+}
+
+func TestREPLSQLite(t *testing.T) {
+	t.Logf("Testing REPL with sqlite3...")
+	r := spawnREPL(t, SQLite)
+	testEval(t, r, []evalTest{
+		{"create table t (n integer);", "", nil},
+		{"insert into t\nvalues (1), (2), (3);", "", nil},
+		{"select sum(n)\nfrom t;", "6\n", nil},
+	})
+}
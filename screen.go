@@ -0,0 +1,991 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Color is the foreground or background colour of a Cell. The zero value is
+// the terminal's default colour.
+type Color uint32
+
+const (
+	DefaultColor Color = 0
+
+	paletteColor Color = 1 << 24
+	rgbColor     Color = 2 << 24
+)
+
+// PaletteColor returns entry n of the 256 colour palette; 0-7 are the basic
+// ANSI colours and 8-15 their bright variants.
+func PaletteColor(n uint8) Color {
+	return paletteColor | Color(n)
+}
+
+// RGBColor returns a 24-bit colour.
+func RGBColor(r, g, b uint8) Color {
+	return rgbColor | Color(r)<<16 | Color(g)<<8 | Color(b)
+}
+
+// Palette returns the palette index of c, if it is a palette colour.
+func (c Color) Palette() (uint8, bool) {
+	return uint8(c), c&^0xffffff == paletteColor
+}
+
+// RGB returns the components of c, if it is a 24-bit colour.
+func (c Color) RGB() (r, g, b uint8, ok bool) {
+	return uint8(c >> 16), uint8(c >> 8), uint8(c), c&^0xffffff == rgbColor
+}
+
+// Attr is the rendition of a Cell, as set by SGR escape sequences. The zero
+// value is the default rendition.
+type Attr struct {
+	Fg, Bg    Color
+	Bold      bool
+	Dim       bool
+	Italic    bool
+	Underline bool
+	Blink     bool
+	Reverse   bool
+	Hidden    bool
+	Strike    bool
+}
+
+// Cell is one character position on a Screen.
+type Cell struct {
+	Rune rune
+	Attr Attr
+}
+
+var blankCell = Cell{Rune: ' '}
+
+type cursor struct {
+	row, col int
+	attr     Attr
+	origin   bool
+	charsets [2]byte
+	charset  int
+}
+
+// Parser states.
+const (
+	stateGround = iota
+	stateEscape
+	stateCharset
+	stateHash
+	stateCSI
+	stateOSC
+	stateString
+	stateStringEscape
+)
+
+// Screen is a virtual VT100/xterm terminal. Writing the output of a program
+// to it interprets the control and escape sequences into a grid of cells,
+// as a terminal emulator would display them. Wide characters take up a
+// single cell.
+type Screen struct {
+	mu sync.Mutex
+
+	rows, cols int
+	cells      [][]Cell
+	main       [][]Cell // the primary screen while the alternate one is shown
+	tabs       []bool
+
+	cursor
+	saved         cursor
+	altSaved      cursor
+	top, bottom   int // scrolling region, inclusive
+	wrapNext      bool
+	autoWrap      bool
+	insert        bool
+	cursorHidden  bool
+	appCursorKeys bool
+	title         string
+	last          rune
+
+	state   int
+	params  []int
+	private byte
+	target  int // the G0 or G1 character set being designated, or -1
+	osc     []byte
+	pending []byte // an incomplete UTF-8 sequence
+}
+
+// NewScreen returns an empty screen of the given size. A screen has at least
+// one row and one column, whatever size it is given.
+func NewScreen(rows, cols int) *Screen {
+	s := &Screen{}
+	s.reset(rows, cols)
+	return s
+}
+
+func (s *Screen) reset(rows, cols int) {
+	rows, cols = screenSize(rows, cols)
+	s.rows, s.cols = rows, cols
+	s.cells, s.main = newCells(rows, cols), nil
+	s.tabs = make([]bool, cols)
+	for i := 8; i < cols; i += 8 {
+		s.tabs[i] = true
+	}
+	s.cursor = cursor{charsets: [2]byte{'B', 'B'}}
+	s.saved, s.altSaved = s.cursor, s.cursor
+	s.top, s.bottom = 0, rows-1
+	s.wrapNext, s.autoWrap, s.insert = false, true, false
+	s.cursorHidden, s.appCursorKeys = false, false
+	s.title, s.last = "", 0
+	s.state, s.params, s.private, s.osc, s.pending = stateGround, nil, 0, nil, nil
+}
+
+// screenSize raises rows and cols to at least 1: a pty may be given a size
+// of 0x0, but the cursor needs a cell to be on.
+func screenSize(rows, cols int) (int, int) {
+	if rows < 1 {
+		rows = 1
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	return rows, cols
+}
+
+func newCells(rows, cols int) [][]Cell {
+	cells := make([][]Cell, rows)
+	for i := range cells {
+		cells[i] = newLine(cols)
+	}
+	return cells
+}
+
+func newLine(cols int) []Cell {
+	line := make([]Cell, cols)
+	for i := range line {
+		line[i] = blankCell
+	}
+	return line
+}
+
+// Size returns the number of rows and columns of the screen.
+func (s *Screen) Size() (rows, cols int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rows, s.cols
+}
+
+// Resize changes the size of the screen, keeping the content that still fits
+// in the top left corner.
+func (s *Screen) Resize(rows, cols int) {
+	rows, cols = screenSize(rows, cols)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resize := func(old [][]Cell) [][]Cell {
+		cells := newCells(rows, cols)
+		for i := 0; i < rows && i < len(old); i++ {
+			copy(cells[i], old[i])
+		}
+		return cells
+	}
+	s.cells = resize(s.cells)
+	if s.main != nil {
+		s.main = resize(s.main)
+	}
+	tabs := make([]bool, cols)
+	copy(tabs, s.tabs)
+	for i := len(s.tabs) + (8-len(s.tabs)%8)%8; i < cols; i += 8 {
+		tabs[i] = true
+	}
+	s.tabs = tabs
+	s.rows, s.cols = rows, cols
+	s.top, s.bottom = 0, rows-1
+	s.row, s.col = s.clampRow(s.row), s.clampCol(s.col)
+	s.wrapNext = false
+}
+
+// Cursor returns the zero-based position of the cursor.
+func (s *Screen) Cursor() (row, col int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.row, s.col
+}
+
+// CursorVisible reports whether the program has left the cursor visible.
+func (s *Screen) CursorVisible() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.cursorHidden
+}
+
+// applicationCursorKeys reports whether the program has put the cursor keys
+// into application mode.
+func (s *Screen) applicationCursorKeys() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.appCursorKeys
+}
+
+// Title returns the window title last set by the program.
+func (s *Screen) Title() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.title
+}
+
+// Cell returns the cell at the zero-based row and col.
+func (s *Screen) Cell(row, col int) Cell {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if row < 0 || row >= s.rows || col < 0 || col >= s.cols {
+		return blankCell
+	}
+	return s.cells[row][col]
+}
+
+// Line returns the text of the zero-based row n, without trailing spaces.
+func (s *Screen) Line(n int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n < 0 || n >= s.rows {
+		return ""
+	}
+	return s.line(n)
+}
+
+func (s *Screen) line(n int) string {
+	var b strings.Builder
+	for _, c := range s.cells[n] {
+		b.WriteRune(c.Rune)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// Text returns the text of every row, separated by newlines.
+func (s *Screen) Text() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lines := make([]string, s.rows)
+	for i := range lines {
+		lines[i] = s.line(i)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Contains reports whether text appears on any row of the screen.
+func (s *Screen) Contains(text string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < s.rows; i++ {
+		if strings.Contains(s.line(i), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Write interprets p as terminal output. It never fails.
+func (s *Screen) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := p
+	if len(s.pending) > 0 {
+		data = append(s.pending, p...)
+		s.pending = nil
+	}
+	for len(data) > 0 {
+		b := data[0]
+		if b < utf8.RuneSelf || s.state != stateGround {
+			s.byte(b)
+			data = data[1:]
+			continue
+		}
+		if !utf8.FullRune(data) {
+			s.pending = append([]byte(nil), data...)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		s.put(r)
+		data = data[size:]
+	}
+	return len(p), nil
+}
+
+func (s *Screen) byte(b byte) {
+	switch s.state {
+	case stateGround:
+		if b < 0x20 || b == 0x7f {
+			s.control(b)
+		} else {
+			s.put(rune(b))
+		}
+	case stateEscape:
+		s.escape(b)
+	case stateCharset:
+		if s.target >= 0 {
+			s.charsets[s.target] = b
+		}
+		s.state = stateGround
+	case stateHash:
+		if b == '8' {
+			// DECALN fills the screen with E's.
+			for _, line := range s.cells {
+				for i := range line {
+					line[i] = Cell{Rune: 'E'}
+				}
+			}
+		}
+		s.state = stateGround
+	case stateCSI:
+		s.csiByte(b)
+	case stateOSC:
+		switch b {
+		case 0x07:
+			s.oscEnd()
+		case 0x1b:
+			s.state = stateStringEscape
+		default:
+			s.osc = append(s.osc, b)
+		}
+	case stateString:
+		if b == 0x1b {
+			s.state = stateStringEscape
+		} else if b == 0x07 {
+			s.state = stateGround
+		}
+	case stateStringEscape:
+		// ESC \ ends the string; anything else starts a new sequence.
+		if s.osc != nil {
+			s.oscEnd()
+		}
+		s.state = stateGround
+		if b != '\\' {
+			s.state = stateEscape
+			s.escape(b)
+		}
+	}
+}
+
+func (s *Screen) control(b byte) {
+	switch b {
+	case '\b':
+		if s.col > 0 {
+			s.col--
+		}
+		s.wrapNext = false
+	case '\t':
+		s.tab(1)
+	case '\n', '\v', '\f':
+		s.lineFeed()
+	case '\r':
+		s.col = 0
+		s.wrapNext = false
+	case 0x0e: // SO
+		s.charset = 1
+	case 0x0f: // SI
+		s.charset = 0
+	case 0x1b:
+		s.state = stateEscape
+	}
+}
+
+func (s *Screen) escape(b byte) {
+	s.state = stateGround
+	switch b {
+	case '[':
+		s.state = stateCSI
+		s.params = s.params[:0]
+		s.private = 0
+	case ']':
+		s.state = stateOSC
+		s.osc = []byte{}
+	case 'P', 'X', '^', '_':
+		s.state = stateString
+		s.osc = nil
+	case '(', ')':
+		s.state = stateCharset
+		s.target = int(b - '(')
+	case '*', '+', '-', '.', '/':
+		s.state = stateCharset
+		s.target = -1
+	case '#':
+		s.state = stateHash
+	case '7':
+		s.saved = s.cursor
+	case '8':
+		s.restoreCursor(s.saved)
+	case 'D':
+		s.index()
+	case 'E':
+		s.col = 0
+		s.index()
+	case 'H':
+		s.tabs[s.col] = true
+	case 'M':
+		s.reverseIndex()
+	case 'c':
+		s.reset(s.rows, s.cols)
+	}
+}
+
+func (s *Screen) csiByte(b byte) {
+	switch {
+	case b >= '0' && b <= '9':
+		if len(s.params) == 0 {
+			s.params = append(s.params, 0)
+		}
+		i := len(s.params) - 1
+		if s.params[i] < 0 {
+			s.params[i] = 0
+		}
+		if s.params[i] < 100000 {
+			s.params[i] = s.params[i]*10 + int(b-'0')
+		}
+	case b == ';' || b == ':':
+		if len(s.params) == 0 {
+			s.params = append(s.params, -1)
+		}
+		s.params = append(s.params, -1)
+	case b >= '<' && b <= '?':
+		s.private = b
+	case b >= 0x20 && b <= 0x2f:
+		// Intermediate bytes select variants we do not distinguish.
+	case b >= 0x40 && b <= 0x7e:
+		s.state = stateGround
+		s.csi(b)
+	case b == 0x1b:
+		s.state = stateEscape
+	case b < 0x20:
+		s.control(b)
+	}
+}
+
+// param returns parameter i, or def if it was omitted or zero.
+func (s *Screen) param(i, def int) int {
+	if i >= len(s.params) || s.params[i] <= 0 {
+		return def
+	}
+	return s.params[i]
+}
+
+func (s *Screen) csi(final byte) {
+	if s.private == '?' {
+		switch final {
+		case 'h':
+			s.setPrivateModes(true)
+		case 'l':
+			s.setPrivateModes(false)
+		}
+		return
+	}
+	if s.private != 0 {
+		return
+	}
+	n := s.param(0, 1)
+	switch final {
+	case '@':
+		s.insertCells(n)
+	case 'A':
+		s.moveTo(s.up(n), s.col)
+	case 'B', 'e':
+		s.moveTo(s.down(n), s.col)
+	case 'C', 'a':
+		s.moveTo(s.row, s.col+n)
+	case 'D':
+		s.moveTo(s.row, s.col-n)
+	case 'E':
+		s.moveTo(s.down(n), 0)
+	case 'F':
+		s.moveTo(s.up(n), 0)
+	case 'G', '`':
+		s.moveTo(s.row, n-1)
+	case 'H', 'f':
+		row := s.param(0, 1) - 1
+		if s.origin {
+			row += s.top
+		}
+		s.moveTo(row, s.param(1, 1)-1)
+	case 'I':
+		s.tab(n)
+	case 'J':
+		s.eraseDisplay(s.param(0, 0))
+	case 'K':
+		s.eraseLine(s.param(0, 0))
+	case 'L':
+		s.insertLines(n)
+	case 'M':
+		s.deleteLines(n)
+	case 'P':
+		s.deleteCells(n)
+	case 'S':
+		s.scrollUp(n)
+	case 'T':
+		s.scrollDown(n)
+	case 'X':
+		s.fill(s.row, s.col, s.col+n)
+	case 'Z':
+		for ; n > 0 && s.col > 0; n-- {
+			s.col--
+			for s.col > 0 && !s.tabs[s.col] {
+				s.col--
+			}
+		}
+	case 'b':
+		for i := 0; i < n && s.last != 0; i++ {
+			s.put(s.last)
+		}
+	case 'd':
+		row := n - 1
+		if s.origin {
+			row += s.top
+		}
+		s.moveTo(row, s.col)
+	case 'g':
+		switch s.param(0, 0) {
+		case 0:
+			s.tabs[s.col] = false
+		case 3:
+			for i := range s.tabs {
+				s.tabs[i] = false
+			}
+		}
+	case 'h', 'l':
+		for i := range s.params {
+			if s.params[i] == 4 {
+				s.insert = final == 'h'
+			}
+		}
+	case 'm':
+		s.sgr()
+	case 'r':
+		top, bottom := s.param(0, 1)-1, s.param(1, s.rows)-1
+		if bottom >= s.rows {
+			bottom = s.rows - 1
+		}
+		if top < bottom {
+			s.top, s.bottom = top, bottom
+			s.moveTo(0, 0)
+		}
+	case 's':
+		s.saved = s.cursor
+	case 'u':
+		s.restoreCursor(s.saved)
+	}
+}
+
+func (s *Screen) setPrivateModes(on bool) {
+	for _, mode := range s.params {
+		switch mode {
+		case 1:
+			s.appCursorKeys = on
+		case 6:
+			s.origin = on
+			s.moveTo(0, 0)
+		case 7:
+			s.autoWrap = on
+		case 25:
+			s.cursorHidden = !on
+		case 47, 1047:
+			s.alternateScreen(on)
+		case 1048:
+			if on {
+				s.altSaved = s.cursor
+			} else {
+				s.restoreCursor(s.altSaved)
+			}
+		case 1049:
+			if on {
+				s.altSaved = s.cursor
+				s.alternateScreen(true)
+			} else {
+				s.alternateScreen(false)
+				s.restoreCursor(s.altSaved)
+			}
+		}
+	}
+}
+
+func (s *Screen) alternateScreen(on bool) {
+	if on && s.main == nil {
+		s.main = s.cells
+		s.cells = newCells(s.rows, s.cols)
+	} else if !on && s.main != nil {
+		s.cells = s.main
+		s.main = nil
+	}
+}
+
+func (s *Screen) sgr() {
+	if len(s.params) == 0 {
+		s.attr = Attr{}
+		return
+	}
+	for i := 0; i < len(s.params); i++ {
+		p := s.params[i]
+		if p < 0 {
+			p = 0
+		}
+		switch {
+		case p == 0:
+			s.attr = Attr{}
+		case p == 1:
+			s.attr.Bold = true
+		case p == 2:
+			s.attr.Dim = true
+		case p == 3:
+			s.attr.Italic = true
+		case p == 4:
+			s.attr.Underline = true
+		case p == 5 || p == 6:
+			s.attr.Blink = true
+		case p == 7:
+			s.attr.Reverse = true
+		case p == 8:
+			s.attr.Hidden = true
+		case p == 9:
+			s.attr.Strike = true
+		case p == 21 || p == 22:
+			s.attr.Bold, s.attr.Dim = false, false
+		case p == 23:
+			s.attr.Italic = false
+		case p == 24:
+			s.attr.Underline = false
+		case p == 25:
+			s.attr.Blink = false
+		case p == 27:
+			s.attr.Reverse = false
+		case p == 28:
+			s.attr.Hidden = false
+		case p == 29:
+			s.attr.Strike = false
+		case p >= 30 && p <= 37:
+			s.attr.Fg = PaletteColor(uint8(p - 30))
+		case p == 38:
+			s.attr.Fg, i = s.extendedColor(i)
+		case p == 39:
+			s.attr.Fg = DefaultColor
+		case p >= 40 && p <= 47:
+			s.attr.Bg = PaletteColor(uint8(p - 40))
+		case p == 48:
+			s.attr.Bg, i = s.extendedColor(i)
+		case p == 49:
+			s.attr.Bg = DefaultColor
+		case p >= 90 && p <= 97:
+			s.attr.Fg = PaletteColor(uint8(p - 90 + 8))
+		case p >= 100 && p <= 107:
+			s.attr.Bg = PaletteColor(uint8(p - 100 + 8))
+		}
+	}
+}
+
+// extendedColor parses the 38;5;n and 38;2;r;g;b forms starting at params[i],
+// returning the colour and the index of the last parameter used.
+func (s *Screen) extendedColor(i int) (Color, int) {
+	arg := func(j int) uint8 {
+		if j >= len(s.params) || s.params[j] < 0 {
+			return 0
+		}
+		return uint8(s.params[j])
+	}
+	switch arg(i + 1) {
+	case 5:
+		return PaletteColor(arg(i + 2)), i + 2
+	case 2:
+		return RGBColor(arg(i+2), arg(i+3), arg(i+4)), i + 4
+	}
+	return DefaultColor, len(s.params)
+}
+
+func (s *Screen) oscEnd() {
+	text := string(s.osc)
+	s.osc = nil
+	s.state = stateGround
+	if i := strings.IndexByte(text, ';'); i > 0 {
+		if n, err := strconv.Atoi(text[:i]); err == nil && (n == 0 || n == 2) {
+			s.title = text[i+1:]
+		}
+	}
+}
+
+// decGraphics maps the DEC special graphics character set, used by curses
+// programs to draw boxes, onto Unicode.
+var decGraphics = map[rune]rune{
+	'_': ' ', '`': '◆', 'a': '▒', 'b': '␉', 'c': '␌', 'd': '␍', 'e': '␊',
+	'f': '°', 'g': '±', 'h': '␤', 'i': '␋', 'j': '┘', 'k': '┐', 'l': '┌',
+	'm': '└', 'n': '┼', 'o': '⎺', 'p': '⎻', 'q': '─', 'r': '⎼', 's': '⎽',
+	't': '├', 'u': '┤', 'v': '┴', 'w': '┬', 'x': '│', 'y': '≤', 'z': '≥',
+	'{': 'π', '|': '≠', '}': '£', '~': '·',
+}
+
+func (s *Screen) put(r rune) {
+	if r >= 0x80 && r < 0xa0 {
+		// C1 controls are not supported.
+		return
+	}
+	if s.charsets[s.charset] == '0' {
+		if g, ok := decGraphics[r]; ok {
+			r = g
+		}
+	}
+	if s.wrapNext && s.autoWrap {
+		s.col = 0
+		s.index()
+	}
+	s.wrapNext = false
+	if s.insert {
+		s.insertCells(1)
+	}
+	s.cells[s.row][s.col] = Cell{Rune: r, Attr: s.attr}
+	s.last = r
+	if s.col == s.cols-1 {
+		s.wrapNext = true
+	} else {
+		s.col++
+	}
+}
+
+func (s *Screen) clampRow(row int) int {
+	if row < 0 {
+		return 0
+	}
+	if row >= s.rows {
+		return s.rows - 1
+	}
+	return row
+}
+
+func (s *Screen) clampCol(col int) int {
+	if col < 0 {
+		return 0
+	}
+	if col >= s.cols {
+		return s.cols - 1
+	}
+	return col
+}
+
+// moveTo moves the cursor, keeping it on the screen and, in origin mode,
+// inside the scrolling region.
+func (s *Screen) moveTo(row, col int) {
+	if s.origin {
+		if row < s.top {
+			row = s.top
+		}
+		if row > s.bottom {
+			row = s.bottom
+		}
+	}
+	s.row, s.col = s.clampRow(row), s.clampCol(col)
+	s.wrapNext = false
+}
+
+// up returns the row n lines above the cursor, stopping at the top margin if
+// the cursor is below it.
+func (s *Screen) up(n int) int {
+	if s.row >= s.top && s.row-n < s.top {
+		return s.top
+	}
+	return s.row - n
+}
+
+// down returns the row n lines below the cursor, stopping at the bottom
+// margin if the cursor is above it.
+func (s *Screen) down(n int) int {
+	if s.row <= s.bottom && s.row+n > s.bottom {
+		return s.bottom
+	}
+	return s.row + n
+}
+
+func (s *Screen) restoreCursor(c cursor) {
+	s.cursor = c
+	s.row, s.col = s.clampRow(c.row), s.clampCol(c.col)
+	s.wrapNext = false
+}
+
+func (s *Screen) tab(n int) {
+	for ; n > 0 && s.col < s.cols-1; n-- {
+		s.col++
+		for s.col < s.cols-1 && !s.tabs[s.col] {
+			s.col++
+		}
+	}
+	s.wrapNext = false
+}
+
+func (s *Screen) lineFeed() {
+	s.index()
+	s.wrapNext = false
+}
+
+// index moves the cursor down a line, scrolling at the bottom margin.
+func (s *Screen) index() {
+	if s.row == s.bottom {
+		s.scrollUp(1)
+	} else if s.row < s.rows-1 {
+		s.row++
+	}
+}
+
+func (s *Screen) reverseIndex() {
+	if s.row == s.top {
+		s.scrollDown(1)
+	} else if s.row > 0 {
+		s.row--
+	}
+	s.wrapNext = false
+}
+
+func (s *Screen) scrollUp(n int) {
+	s.deleteLinesAt(s.top, n)
+}
+
+func (s *Screen) scrollDown(n int) {
+	s.insertLinesAt(s.top, n)
+}
+
+func (s *Screen) insertLines(n int) {
+	if s.row >= s.top && s.row <= s.bottom {
+		s.insertLinesAt(s.row, n)
+		s.col = 0
+	}
+}
+
+func (s *Screen) deleteLines(n int) {
+	if s.row >= s.top && s.row <= s.bottom {
+		s.deleteLinesAt(s.row, n)
+		s.col = 0
+	}
+}
+
+// insertLinesAt inserts n blank lines at row, pushing lines off the bottom
+// of the scrolling region.
+func (s *Screen) insertLinesAt(row, n int) {
+	if n > s.bottom-row+1 {
+		n = s.bottom - row + 1
+	}
+	region := s.cells[row : s.bottom+1]
+	copy(region[n:], region[:len(region)-n])
+	for i := 0; i < n; i++ {
+		region[i] = newLine(s.cols)
+	}
+}
+
+// deleteLinesAt removes n lines at row, pulling blank lines in at the bottom
+// of the scrolling region.
+func (s *Screen) deleteLinesAt(row, n int) {
+	if n > s.bottom-row+1 {
+		n = s.bottom - row + 1
+	}
+	region := s.cells[row : s.bottom+1]
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = newLine(s.cols)
+	}
+}
+
+func (s *Screen) insertCells(n int) {
+	line := s.cells[s.row]
+	if n > s.cols-s.col {
+		n = s.cols - s.col
+	}
+	copy(line[s.col+n:], line[s.col:])
+	s.fill(s.row, s.col, s.col+n)
+}
+
+func (s *Screen) deleteCells(n int) {
+	line := s.cells[s.row]
+	if n > s.cols-s.col {
+		n = s.cols - s.col
+	}
+	copy(line[s.col:], line[s.col+n:])
+	s.fill(s.row, s.cols-n, s.cols)
+}
+
+// fill blanks the cells of row from column from up to, but not including, to.
+func (s *Screen) fill(row, from, to int) {
+	if to > s.cols {
+		to = s.cols
+	}
+	blank := Cell{Rune: ' ', Attr: Attr{Bg: s.attr.Bg}}
+	for i := from; i < to; i++ {
+		s.cells[row][i] = blank
+	}
+}
+
+func (s *Screen) eraseLine(mode int) {
+	switch mode {
+	case 0:
+		s.fill(s.row, s.col, s.cols)
+	case 1:
+		s.fill(s.row, 0, s.col+1)
+	case 2:
+		s.fill(s.row, 0, s.cols)
+	}
+	s.wrapNext = false
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	switch mode {
+	case 0:
+		s.fill(s.row, s.col, s.cols)
+		for i := s.row + 1; i < s.rows; i++ {
+			s.fill(i, 0, s.cols)
+		}
+	case 1:
+		for i := 0; i < s.row; i++ {
+			s.fill(i, 0, s.cols)
+		}
+		s.fill(s.row, 0, s.col+1)
+	case 2, 3:
+		for i := 0; i < s.rows; i++ {
+			s.fill(i, 0, s.cols)
+		}
+	}
+	s.wrapNext = false
+}
+
+// ErrNoScreen is returned by ExpectScreen when the session was not started
+// with SpawnOptions.Screen.
+var ErrNoScreen = errors.New("gexpect: no screen attached")
+
+// Screen returns the virtual terminal attached with SpawnOptions.Screen, or
+// nil. It is kept up to date with everything the child prints, whether or not
+// that output has been read by an Expect method.
+func (expect *ExpectSubprocess) Screen() *Screen {
+	if expect.screen != nil {
+		expect.buf.run()
+	}
+	return expect.screen
+}
+
+// ExpectScreen waits until cond holds for the rendered screen. It does not
+// consume any output. If it times out, the Output of the TimeoutError is the
+// text of the screen.
+func (expect *ExpectSubprocess) ExpectScreen(cond func(*Screen) bool, opts ...Option) error {
+	return expect.ExpectScreenContext(context.Background(), cond, opts...)
+}
+
+func (expect *ExpectSubprocess) ExpectScreenContext(ctx context.Context, cond func(*Screen) bool, opts ...Option) error {
+	if expect.screen == nil {
+		return ErrNoScreen
+	}
+	callCtx, cancel, timeout := expect.callContext(ctx, "screen condition", opts)
+	defer cancel()
+	err := expect.buf.wait(callCtx, func(data []byte, atEOF bool) (int, bool) {
+		return 0, cond(expect.screen)
+	})
+	if err != nil && callCtx.Err() != nil {
+		err = expect.interrupted(ctx, timeout, "ExpectScreen", "screen condition")
+		if timeoutErr, ok := err.(*TimeoutError); ok {
+			timeoutErr.Output = expect.log.redactOutput([]byte(expect.screen.Text()))
+		}
+		return err
+	}
+	if err != nil {
+		return expect.readError("ExpectScreen", "screen condition", err)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScreenCursorAndErase(t *testing.T) {
+	t.Logf("Testing Screen cursor movement and erasing...")
+	s := NewScreen(5, 20)
+	s.Write([]byte("hello world\r\nsecond line"))
+	if row, col := s.Cursor(); row != 1 || col != 11 {
+		t.Fatalf("Expected the cursor at 1,11, got %d,%d", row, col)
+	}
+	// Move to row 1, column 7 and erase to the end of the line, then
+	// overwrite the start of the first line.
+	s.Write([]byte("\x1b[2;8H\x1b[K\x1b[1;1HHELLO"))
+	if got := s.Text(); got != "HELLO world\nsecond\n\n\n" {
+		t.Fatalf("Unexpected screen:\n%q", got)
+	}
+	s.Write([]byte("\x1b[2J\x1b[3;5Hx\x1b[Ay\x1b[2Dz"))
+	if got := s.Line(1); got != "    zy" {
+		t.Fatalf("Expected relative moves to land on row 1, got %q", got)
+	}
+	if got := s.Line(2); got != "    x" {
+		t.Fatalf("Expected x on row 2, got %q", got)
+	}
+}
+
+func TestScreenWrapAndScroll(t *testing.T) {
+	t.Logf("Testing Screen line wrapping and scrolling...")
+	s := NewScreen(3, 5)
+	s.Write([]byte("abcdefg"))
+	if got := s.Text(); got != "abcde\nfg\n" {
+		t.Fatalf("Expected the line to wrap, got %q", got)
+	}
+	s.Write([]byte("\r\none\r\ntwo"))
+	if got := s.Text(); got != "fg\none\ntwo" {
+		t.Fatalf("Expected the screen to scroll, got %q", got)
+	}
+
+	// Scroll only the bottom two rows.
+	s.Write([]byte("\x1b[2;3r\x1b[3;1H\nxyz"))
+	if got := s.Text(); got != "fg\ntwo\nxyz" {
+		t.Fatalf("Expected only the scrolling region to move, got %q", got)
+	}
+}
+
+func TestScreenAttributes(t *testing.T) {
+	t.Logf("Testing Screen colours and attributes...")
+	s := NewScreen(2, 10)
+	s.Write([]byte("\x1b[1;31mR\x1b[0m\x1b[4;38;5;200mP\x1b[48;2;1;2;3mT\x1b[mN"))
+	if c := s.Cell(0, 0); c.Rune != 'R' || !c.Attr.Bold || c.Attr.Fg != PaletteColor(1) {
+		t.Fatalf("Expected a bold red R, got %+v", c)
+	}
+	if c := s.Cell(0, 1); !c.Attr.Underline || c.Attr.Bold || c.Attr.Fg != PaletteColor(200) {
+		t.Fatalf("Expected an underlined P in colour 200, got %+v", c)
+	}
+	if r, g, b, ok := s.Cell(0, 2).Attr.Bg.RGB(); !ok || r != 1 || g != 2 || b != 3 {
+		t.Fatalf("Expected T on an RGB background, got %+v", s.Cell(0, 2))
+	}
+	if c := s.Cell(0, 3); c.Attr != (Attr{}) {
+		t.Fatalf("Expected N in the default rendition, got %+v", c)
+	}
+}
+
+func TestScreenAlternateScreen(t *testing.T) {
+	t.Logf("Testing Screen alternate screen and title...")
+	s := NewScreen(3, 10)
+	s.Write([]byte("shell $\x1b]0;my title\x07"))
+	s.Write([]byte("\x1b[?1049h\x1b[?25l\x1b[Hfull screen"))
+	if !s.Contains("full scree") || s.Contains("shell") {
+		t.Fatalf("Expected the alternate screen, got %q", s.Text())
+	}
+	if s.CursorVisible() {
+		t.Fatalf("Expected the cursor to be hidden")
+	}
+	s.Write([]byte("\x1b[?1049l\x1b[?25h"))
+	if got := s.Text(); got != "shell $\n\n" {
+		t.Fatalf("Expected the primary screen to be restored, got %q", got)
+	}
+	if row, col := s.Cursor(); row != 0 || col != 7 {
+		t.Fatalf("Expected the cursor to be restored to 0,7, got %d,%d", row, col)
+	}
+	if got := s.Title(); got != "my title" {
+		t.Fatalf("Expected the title to be set, got %q", got)
+	}
+}
+
+func TestScreenSplitUTF8(t *testing.T) {
+	t.Logf("Testing Screen with sequences split across writes...")
+	s := NewScreen(1, 10)
+	data := []byte("hé\x1b[31ml─o")
+	for i := range data {
+		s.Write(data[i : i+1])
+	}
+	if got := s.Line(0); got != "hél─o" {
+		t.Fatalf("Unexpected line %q", got)
+	}
+	if c := s.Cell(0, 2); c.Attr.Fg != PaletteColor(1) {
+		t.Fatalf("Expected a red l, got %+v", c)
+	}
+}
+
+func TestScreenZeroSize(t *testing.T) {
+	t.Logf("Testing Screen with a size of 0x0...")
+	s := NewScreen(0, 0)
+	s.Write([]byte("ab\r\n\x1b[5;5Hc"))
+	if rows, cols := s.Size(); rows != 1 || cols != 1 {
+		t.Fatalf("Expected a 1x1 screen, got %dx%d", rows, cols)
+	}
+	s.Resize(3, 3)
+	s.Resize(0, 2)
+	s.Write([]byte("xyz\x1b[2J"))
+
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"cat"},
+		Screen:  true,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if err = child.SetWinSize(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	child.SendLine("hello")
+	if err = child.Expect("hello"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExpectScreen(t *testing.T) {
+	t.Logf("Testing ExpectScreen...")
+	child, err := SpawnWithOptions(SpawnOptions{
+		Args:    []string{"sh", "-c", `printf '\033[2J\033[5;10Hmenu\033[6;10H> item'; sleep 5`},
+		Screen:  true,
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+
+	if rows, cols := child.Screen().Size(); rows != 24 || cols != 80 {
+		t.Fatalf("Expected a 24x80 screen, got %dx%d", rows, cols)
+	}
+	err = child.ExpectScreen(func(s *Screen) bool {
+		return strings.TrimSpace(s.Line(5)) == "> item"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := child.Screen().Line(4); got != "         menu" {
+		t.Fatalf("Unexpected line %q", got)
+	}
+	// The output is still there for the other Expect methods.
+	if err = child.Expect("menu"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = child.ExpectScreen(func(s *Screen) bool {
+		return s.Contains("never")
+	}, WithTimeout(100*time.Millisecond))
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || !strings.Contains(string(timeoutErr.Output), "> item") {
+		t.Fatalf("Expected a TimeoutError with the screen contents, got %v", err)
+	}
+
+	if err = child.SetWinSize(10, 40); err != nil {
+		t.Fatal(err)
+	}
+	if rows, cols := child.Screen().Size(); rows != 10 || cols != 40 {
+		t.Fatalf("Expected the screen to follow SetWinSize, got %dx%d", rows, cols)
+	}
+}
+
+func TestExpectScreenWithoutScreen(t *testing.T) {
+	child, err := Spawn("echo hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer child.Close()
+	if child.Screen() != nil {
+		t.Fatalf("Expected no screen")
+	}
+	if err = child.ExpectScreen(func(*Screen) bool { return true }); err != ErrNoScreen {
+		t.Fatalf("Expected ErrNoScreen, got %v", err)
+	}
+}
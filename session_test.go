@@ -0,0 +1,80 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveEcho answers a tiny line protocol on conn until QUIT.
+func serveEcho(conn net.Conn) {
+	defer conn.Close()
+	conn.Write([]byte("220 ready\r\n"))
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "QUIT" {
+			conn.Write([]byte("221 bye\r\n"))
+			return
+		}
+		conn.Write([]byte("250 " + strings.ToUpper(line) + "\r\n"))
+	}
+}
+
+func TestNewSession(t *testing.T) {
+	t.Logf("Testing NewSession over a net.Conn...")
+	client, server := net.Pipe()
+	go serveEcho(server)
+	session := NewSession(client, WithTimeout(5*time.Second))
+	defer session.Close()
+
+	if err := session.Expect("220 ready\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.SendLine("hello"); err != nil {
+		t.Fatal(err)
+	}
+	line, err := session.ReadLine()
+	if err != nil || line != "250 HELLO\r" {
+		t.Fatalf("Expected 250 HELLO, got %q, %v", line, err)
+	}
+	session.SendLine("QUIT")
+	if err = session.Expect("221 bye\r\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Wait(); err != nil {
+		t.Fatalf("Expected the session to end with the connection, got %v", err)
+	}
+	if _, err = session.ReadLine(); !errors.Is(err, ErrEOF) {
+		t.Fatalf("Expected the end of output, got %v", err)
+	}
+	if status := session.ExitStatus(); status != -1 {
+		t.Fatalf("Expected no exit status, got %d", status)
+	}
+	if _, _, err = session.GetWinSize(); err != ErrNoPty {
+		t.Fatalf("Expected ErrNoPty, got %v", err)
+	}
+}
+
+func TestNewSessionClose(t *testing.T) {
+	t.Logf("Testing Close on a NewSession...")
+	client, server := net.Pipe()
+	defer server.Close()
+	session := NewSession(client)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		session.Close()
+	}()
+	if err := session.Expect("never"); !errors.Is(err, ErrEOF) {
+		t.Fatalf("Expected Close to end the session, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Shell runs commands one at a time in an interactive sh, bash or zsh and
+// collects what each prints and its exit status. The shell's prompt is
+// replaced with one made unique to the session, so output that looks like a
+// prompt does not confuse it.
+type Shell struct {
+	*ExpectSubprocess
+	token  string // makes the prompt and the exit status marker unique
+	prompt string
+	seq    int // numbers the commands, so the marker of one that timed out is told apart
+}
+
+// SpawnShell starts shell, or $SHELL if it is empty, and prepares it with
+// NewShell. bash and zsh are started without reading their startup files.
+func SpawnShell(shell string, opts ...Option) (*Shell, error) {
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = "/bin/sh"
+	}
+	args := []string{shell}
+	switch filepath.Base(shell) {
+	case "bash":
+		args = append(args, "--noprofile", "--norc", "--noediting")
+	case "zsh":
+		args = append(args, "-f")
+	}
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	expect, err := SpawnWithOptions(SpawnOptions{Args: args, Term: "dumb", Timeout: o.timeout})
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewShell(expect)
+	if err != nil {
+		expect.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewShell prepares the shell running in expect, such as a login shell
+// reached over SSH, for Run: it turns off echo and history and sets a prompt
+// of its own. The session default timeout applies.
+func NewShell(expect *ExpectSubprocess) (*Shell, error) {
+	token := make([]byte, 8)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	tok := hex.EncodeToString(token)
+	s := &Shell{
+		ExpectSubprocess: expect,
+		token:            tok,
+		prompt:           "<gx-" + tok + "> ",
+	}
+	// The prompt is assigned in pieces so that the command line, echoed
+	// before echo is off, does not contain it.
+	setup := `stty -echo 2>/dev/null; unset HISTFILE; ` +
+		`[ -n "$BASH_VERSION" ] && set +o history && bind 'set enable-bracketed-paste off' 2>/dev/null; ` +
+		`[ -n "$ZSH_VERSION" ] && unsetopt zle prompt_cr prompt_sp; ` +
+		`PROMPT_COMMAND=; PS2=; RPROMPT=; PS1='<gx-'"` + tok + `"'> '` + "\n"
+	if err := expect.Send(setup); err != nil {
+		return nil, err
+	}
+	if err := expect.Expect(s.prompt); err != nil {
+		return nil, fmt.Errorf("gexpect: setting up the shell: %w", err)
+	}
+	return s, nil
+}
+
+// Run runs cmd, which may span several lines, and returns what it printed,
+// with line endings as "\n", and its exit status. The command must not read
+// its standard input. If Run times out, the command keeps running, and the
+// next Run waits for it and drops what it printed.
+func (s *Shell) Run(cmd string, opts ...Option) (output string, exitCode int, err error) {
+	return s.RunContext(context.Background(), cmd, opts...)
+}
+
+// RunContext is Run under ctx. opts apply to each wait for the shell.
+func (s *Shell) RunContext(ctx context.Context, cmd string, opts ...Option) (output string, exitCode int, err error) {
+	// The exit status is printed after a marker which, like the prompt, is
+	// split in two on the command line. The marker carries the number of
+	// the command: a command that Run gave up on prints its own later, and
+	// that is skipped along with its output.
+	s.seq++
+	status := fmt.Sprintf(`printf 'gx%%s-%%d:%%d:\n' %s %d "$?"`, s.token, s.seq)
+	if err := s.Send(cmd + "\n" + status + "\n"); err != nil {
+		return "", -1, err
+	}
+	var code []byte
+	for {
+		if err := s.ExpectContext(ctx, "gx"+s.token+"-", opts...); err != nil {
+			return "", -1, err
+		}
+		output = s.Before()
+		seq, err := s.ReadUntilContext(ctx, ':', opts...)
+		if err != nil {
+			return "", -1, err
+		}
+		if code, err = s.ReadUntilContext(ctx, ':', opts...); err != nil {
+			return "", -1, err
+		}
+		if string(seq) == strconv.Itoa(s.seq) {
+			break
+		}
+		if err := s.ExpectContext(ctx, s.prompt, opts...); err != nil {
+			return "", -1, err
+		}
+	}
+	output = strings.ReplaceAll(output, s.prompt, "")
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	exitCode, err = strconv.Atoi(string(code))
+	if err != nil {
+		return output, -1, fmt.Errorf("gexpect: bad exit status %q from the shell", code)
+	}
+	if err := s.ExpectContext(ctx, s.prompt, opts...); err != nil {
+		return output, exitCode, err
+	}
+	return output, exitCode, nil
+}
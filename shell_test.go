@@ -0,0 +1,70 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestShellRun(t *testing.T) {
+	for _, name := range []string{"sh", "bash", "zsh"} {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Logf("Skipping %s: %v", name, err)
+			continue
+		}
+		t.Logf("Testing Shell.Run with %s...", name)
+		shell, err := SpawnShell(name, WithTimeout(5*time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			cmd    string
+			output string
+			code   int
+		}{
+			{"echo hello", "hello\n", 0},
+			{"printf 'no newline'", "no newline", 0},
+			{"false", "", 1},
+			{"(exit 7)", "", 7},
+			{"echo '$ '; echo '>>> # gx:0:'; echo '<gx-> '", "$ \n>>> # gx:0:\n<gx-> \n", 0},
+			{"for i in 1 2 3\ndo\n  echo $i\ndone", "1\n2\n3\n", 0},
+			{"x=42", "", 0},
+			{"echo $x; (exit 2)", "42\n", 2},
+		}
+		for _, test := range tests {
+			output, code, err := shell.Run(test.cmd)
+			if err != nil {
+				t.Fatalf("%s: Run(%q): %v", name, test.cmd, err)
+			}
+			if output != test.output || code != test.code {
+				t.Errorf("%s: Run(%q) = %q, %d; expected %q, %d", name, test.cmd, output, code, test.output, test.code)
+			}
+		}
+		shell.Close()
+	}
+}
+
+func TestShellRunAfterTimeout(t *testing.T) {
+	t.Logf("Testing Shell.Run after a command timed out...")
+	shell, err := SpawnShell("sh", WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shell.Close()
+	if _, _, err := shell.Run("sleep 0.5; echo A; (exit 3)", WithTimeout(100*time.Millisecond)); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Expected the first command to time out, got %v", err)
+	}
+	for _, word := range []string{"B", "C"} {
+		output, code, err := shell.Run("echo " + word)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output != word+"\n" || code != 0 {
+			t.Fatalf("Expected %q, 0 from echo %s, got %q, %d", word+"\n", word, output, code)
+		}
+	}
+}
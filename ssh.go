@@ -0,0 +1,292 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHOptions describes how DialSSH logs in and the terminal it requests.
+type SSHOptions struct {
+	User string
+	// Password is tried with the password and keyboard-interactive methods.
+	Password string
+	// Signers are the private keys tried with the publickey method.
+	Signers []ssh.Signer
+	// Agent also tries the keys of the agent listening on SSH_AUTH_SOCK.
+	Agent bool
+
+	// KnownHosts lists the known_hosts files the server's host key is
+	// checked against; the default is ~/.ssh/known_hosts.
+	KnownHosts []string
+	// HostKeyCallback, if set, checks the host key instead of KnownHosts.
+	HostKeyCallback ssh.HostKeyCallback
+
+	// Term, Rows and Cols describe the pty requested for the session. The
+	// defaults are "xterm" and 24x80.
+	Term       string
+	Rows, Cols uint16
+
+	// Command is run instead of the login shell.
+	Command string
+
+	// Timeout limits connecting and logging in, and is the session default
+	// timeout.
+	Timeout time.Duration
+}
+
+// sshConn is the interactive session of an SSH connection.
+type sshConn struct {
+	client  *ssh.Client // closed with the session, if set
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+
+	mu         sync.Mutex
+	rows, cols uint16
+
+	waitOnce sync.Once
+	waitErr  error
+	status   int
+	killedBy syscall.Signal // the signal that ended the command, if known
+}
+
+func (c *sshConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *sshConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *sshConn) Close() error {
+	err := c.session.Close()
+	if c.client != nil {
+		if cerr := c.client.Close(); err == nil || err == io.EOF {
+			err = cerr
+		}
+	}
+	if errors.Is(err, net.ErrClosed) || err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+func (c *sshConn) setWinSize(rows, cols uint16) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.session.WindowChange(int(rows), int(cols)); err != nil {
+		return err
+	}
+	c.rows, c.cols = rows, cols
+	return nil
+}
+
+func (c *sshConn) getWinSize() (rows, cols uint16, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rows, c.cols, nil
+}
+
+func (c *sshConn) wait() error {
+	c.waitOnce.Do(func() {
+		c.status = -1
+		c.waitErr = c.session.Wait()
+		var exitErr *ssh.ExitError
+		switch {
+		case c.waitErr == nil:
+			c.status = 0
+		case errors.As(c.waitErr, &exitErr) && exitErr.Signal() == "":
+			c.status = exitErr.ExitStatus()
+		case exitErr != nil:
+			for sig, name := range sshSignals {
+				if string(name) == exitErr.Signal() {
+					c.killedBy = sig
+				}
+			}
+		}
+	})
+	return c.waitErr
+}
+
+func (c *sshConn) exitStatus() int {
+	return c.status
+}
+
+func (c *sshConn) signaled() (syscall.Signal, bool) {
+	return c.killedBy, c.killedBy != 0
+}
+
+// sshSignals names the signals that can be sent over SSH (RFC 4254).
+var sshSignals = map[syscall.Signal]ssh.Signal{
+	syscall.SIGABRT: ssh.SIGABRT,
+	syscall.SIGALRM: ssh.SIGALRM,
+	syscall.SIGFPE:  ssh.SIGFPE,
+	syscall.SIGHUP:  ssh.SIGHUP,
+	syscall.SIGILL:  ssh.SIGILL,
+	syscall.SIGINT:  ssh.SIGINT,
+	syscall.SIGKILL: ssh.SIGKILL,
+	syscall.SIGPIPE: ssh.SIGPIPE,
+	syscall.SIGQUIT: ssh.SIGQUIT,
+	syscall.SIGSEGV: ssh.SIGSEGV,
+	syscall.SIGTERM: ssh.SIGTERM,
+	syscall.SIGUSR1: ssh.SIGUSR1,
+	syscall.SIGUSR2: ssh.SIGUSR2,
+}
+
+func (c *sshConn) signal(sig syscall.Signal) error {
+	name, ok := sshSignals[sig]
+	if !ok {
+		return fmt.Errorf("gexpect: %v cannot be sent over SSH", sig)
+	}
+	return c.session.Signal(name)
+}
+
+// sshConfig returns the client configuration for opts. The returned function
+// releases the connection to the agent, if any, once logged in.
+func sshConfig(opts SSHOptions) (*ssh.ClientConfig, func(), error) {
+	config := &ssh.ClientConfig{
+		User:            opts.User,
+		HostKeyCallback: opts.HostKeyCallback,
+		Timeout:         opts.Timeout,
+	}
+	if config.HostKeyCallback == nil {
+		files := opts.KnownHosts
+		if len(files) == 0 {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, nil, err
+			}
+			files = []string{filepath.Join(home, ".ssh", "known_hosts")}
+		}
+		callback, err := knownhosts.New(files...)
+		if err != nil {
+			return nil, nil, err
+		}
+		config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := callback(hostname, remote, key)
+			var keyErr *knownhosts.KeyError
+			if errors.As(err, &keyErr) {
+				// Want lists the keys known for the host: none means the
+				// host itself is unknown.
+				if len(keyErr.Want) > 0 {
+					return &authError{kind: ErrHostKeyChanged, err: err}
+				}
+				return &authError{kind: ErrHostKeyUnknown, err: err}
+			}
+			return err
+		}
+	}
+
+	release := func() {}
+	signers := opts.Signers
+	if opts.Agent {
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("gexpect: connecting to the SSH agent: %w", err)
+		}
+		release = func() { conn.Close() }
+		agentSigners, err := agent.NewClient(conn).Signers()
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		signers = append(signers[:len(signers):len(signers)], agentSigners...)
+	}
+	if len(signers) > 0 {
+		config.Auth = append(config.Auth, ssh.PublicKeys(signers...))
+	}
+	if opts.Password != "" {
+		config.Auth = append(config.Auth,
+			ssh.Password(opts.Password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = opts.Password
+				}
+				return answers, nil
+			}))
+	}
+	return config, release, nil
+}
+
+// DialSSH logs in to the SSH server at addr, a "host:port", and returns a
+// session running an interactive shell, or opts.Command, on a pty. The
+// session ends when the remote command exits; Wait returns its *ssh.ExitError
+// if it failed, and ExitStatus its exit status. A refused login or host key
+// matches ErrAuthFailed, ErrHostKeyChanged or ErrHostKeyUnknown with
+// errors.Is.
+func DialSSH(addr string, opts SSHOptions) (*ExpectSubprocess, error) {
+	config, release, err := sshConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Dial("tcp", addr, config)
+	release()
+	if err != nil {
+		// The ssh package has no error value for refused credentials.
+		if strings.Contains(err.Error(), "unable to authenticate") {
+			err = &authError{kind: ErrAuthFailed, err: err}
+		}
+		return nil, err
+	}
+	expect, err := NewSSHSession(client, opts)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	expect.buf.f.(*sshConn).client = client
+	return expect, nil
+}
+
+// NewSSHSession opens a session like DialSSH on an existing client, which is
+// left open when the session is closed. Only the terminal and command fields
+// of opts are used.
+func NewSSHSession(client *ssh.Client, opts SSHOptions) (*ExpectSubprocess, error) {
+	if opts.Term == "" {
+		opts.Term = "xterm"
+	}
+	if opts.Rows == 0 || opts.Cols == 0 {
+		opts.Rows, opts.Cols = 24, 80
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	conn := &sshConn{session: session, rows: opts.Rows, cols: opts.Cols, status: -1}
+	if conn.stdin, err = session.StdinPipe(); err == nil {
+		conn.stdout, err = session.StdoutPipe()
+	}
+	if err == nil {
+		err = session.RequestPty(opts.Term, int(opts.Rows), int(opts.Cols), ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 38400,
+			ssh.TTY_OP_OSPEED: 38400,
+		})
+	}
+	if err == nil && opts.Command != "" {
+		err = session.Start(opts.Command)
+	} else if err == nil {
+		err = session.Shell()
+	}
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	expect := NewSession(conn, WithTimeout(opts.Timeout))
+	expect.termName = opts.Term
+	return expect, nil
+}
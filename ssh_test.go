@@ -0,0 +1,377 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// sshServer is an SSH server for the tests whose shell answers a few
+// commands: "size" prints the window size, "exit N" exits with status N, and
+// anything else is echoed back. A signal ends the shell as if it killed it.
+type sshServer struct {
+	addr     string
+	hostKey  ssh.Signer
+	clientPK ssh.PublicKey
+	resized  chan struct{} // receives once per window-change request
+}
+
+func startSSHServer(t *testing.T, clientKey ssh.Signer) *sshServer {
+	s := &sshServer{hostKey: newSigner(t), clientPK: clientKey.PublicKey(), resized: make(chan struct{}, 10)}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if c.User() == "joe" && string(password) == "s3cret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), s.clientPK.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(s.hostKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s.addr = l.Addr().String()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "")
+			continue
+		}
+		ch, requests, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveShell(ch, requests)
+	}
+}
+
+func (s *sshServer) serveShell(ch ssh.Channel, requests <-chan *ssh.Request) {
+	var mu sync.Mutex
+	var rows, cols uint32
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			// string TERM, uint32 cols, uint32 rows, ...
+			n := binary.BigEndian.Uint32(req.Payload)
+			p := req.Payload[4+n:]
+			mu.Lock()
+			cols, rows = binary.BigEndian.Uint32(p), binary.BigEndian.Uint32(p[4:])
+			mu.Unlock()
+			req.Reply(true, nil)
+		case "window-change":
+			mu.Lock()
+			cols, rows = binary.BigEndian.Uint32(req.Payload), binary.BigEndian.Uint32(req.Payload[4:])
+			mu.Unlock()
+			s.resized <- struct{}{}
+		case "signal":
+			var sig struct{ Name string }
+			ssh.Unmarshal(req.Payload, &sig)
+			ch.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}{Signal: sig.Name}))
+			ch.Close()
+		case "shell":
+			req.Reply(true, nil)
+			go func() {
+				defer ch.Close()
+				ch.Write([]byte("$ "))
+				r := bufio.NewReader(ch)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					line = strings.TrimRight(line, "\r\n")
+					ch.Write([]byte(line + "\r\n"))
+					switch {
+					case line == "size":
+						mu.Lock()
+						fmt.Fprintf(ch, "%dx%d\r\n", rows, cols)
+						mu.Unlock()
+					case strings.HasPrefix(line, "exit "):
+						status, _ := strconv.Atoi(line[len("exit "):])
+						ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+						return
+					default:
+						ch.Write([]byte("you said " + line + "\r\n"))
+					}
+					ch.Write([]byte("$ "))
+				}
+			}()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// knownHosts writes a known_hosts file trusting key for addr.
+func knownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+	if err := os.WriteFile(file, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestDialSSH(t *testing.T) {
+	t.Logf("Testing DialSSH with a password...")
+	server := startSSHServer(t, newSigner(t))
+	session, err := DialSSH(server.addr, SSHOptions{
+		User:       "joe",
+		Password:   "s3cret",
+		KnownHosts: []string{knownHosts(t, server.addr, server.hostKey.PublicKey())},
+		Rows:       30,
+		Cols:       100,
+		Timeout:    5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+
+	if err := session.Expect("$ "); err != nil {
+		t.Fatal(err)
+	}
+	session.SendLine("hello")
+	if err := session.Expect("you said hello\r\n$ "); err != nil {
+		t.Fatal(err)
+	}
+	session.SendLine("size")
+	if err := session.Expect("size\r\n30x100\r\n$ "); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.SetWinSize(40, 120); err != nil {
+		t.Fatal(err)
+	}
+	if rows, cols, err := session.GetWinSize(); err != nil || rows != 40 || cols != 120 {
+		t.Fatalf("Expected 40x120, got %dx%d, %v", rows, cols, err)
+	}
+	select {
+	case <-server.resized:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the server to be told the new size")
+	}
+	session.SendLine("size")
+	if err := session.Expect("size\r\n40x120\r\n$ "); err != nil {
+		t.Fatal(err)
+	}
+	session.SendLine("exit 3")
+	var exitErr *ssh.ExitError
+	if err := session.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("Expected an *ssh.ExitError, got %v", err)
+	}
+	if status := session.ExitStatus(); status != 3 {
+		t.Fatalf("Expected exit status 3, got %d", status)
+	}
+}
+
+func TestDialSSHPublicKey(t *testing.T) {
+	t.Logf("Testing DialSSH with a key...")
+	key := newSigner(t)
+	server := startSSHServer(t, key)
+	session, err := DialSSH(server.addr, SSHOptions{
+		User:       "joe",
+		Signers:    []ssh.Signer{key},
+		KnownHosts: []string{knownHosts(t, server.addr, server.hostKey.PublicKey())},
+		Timeout:    5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.Expect("$ "); err != nil {
+		t.Fatal(err)
+	}
+	session.SendLine("exit 0")
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if status := session.ExitStatus(); status != 0 {
+		t.Fatalf("Expected exit status 0, got %d", status)
+	}
+}
+
+func TestDialSSHAgent(t *testing.T) {
+	t.Logf("Testing DialSSH with the keys of an agent...")
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatal(err)
+	}
+	sock := filepath.Join(t.TempDir(), "agent.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", sock)
+
+	server := startSSHServer(t, signer)
+	session, err := DialSSH(server.addr, SSHOptions{
+		User:       "joe",
+		Agent:      true,
+		KnownHosts: []string{knownHosts(t, server.addr, server.hostKey.PublicKey())},
+		Timeout:    5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.Expect("$ "); err != nil {
+		t.Fatal(err)
+	}
+	session.SendLine("exit 0")
+	if err := session.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSSHSignal(t *testing.T) {
+	t.Logf("Testing Signal and Signaled over SSH...")
+	key := newSigner(t)
+	server := startSSHServer(t, key)
+	session, err := DialSSH(server.addr, SSHOptions{
+		User:       "joe",
+		Signers:    []ssh.Signer{key},
+		KnownHosts: []string{knownHosts(t, server.addr, server.hostKey.PublicKey())},
+		Timeout:    5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err := session.Expect("$ "); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := session.Signaled(); ok {
+		t.Fatal("Expected no signal before the shell ends")
+	}
+	if err := session.Signal(syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *ssh.ExitError
+	if err := session.Wait(); !errors.As(err, &exitErr) {
+		t.Fatalf("Expected an *ssh.ExitError, got %v", err)
+	}
+	if sig, ok := session.Signaled(); !ok || sig != syscall.SIGTERM {
+		t.Fatalf("Expected the shell to be killed by SIGTERM, got %v, %v", sig, ok)
+	}
+	if status := session.ExitStatus(); status != -1 {
+		t.Fatalf("Expected exit status -1, got %d", status)
+	}
+}
+
+func TestDialSSHRejects(t *testing.T) {
+	t.Logf("Testing DialSSH with a wrong password and host key...")
+	server := startSSHServer(t, newSigner(t))
+	hosts := knownHosts(t, server.addr, server.hostKey.PublicKey())
+	if _, err := DialSSH(server.addr, SSHOptions{
+		User:       "joe",
+		Password:   "wrong",
+		KnownHosts: []string{hosts},
+		Timeout:    5 * time.Second,
+	}); !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("Expected a wrong password to be rejected, got %v", err)
+	}
+	var keyErr *knownhosts.KeyError
+	if _, err := DialSSH(server.addr, SSHOptions{
+		User:       "joe",
+		Password:   "s3cret",
+		KnownHosts: []string{knownHosts(t, server.addr, newSigner(t).PublicKey())},
+		Timeout:    5 * time.Second,
+	}); !errors.Is(err, ErrHostKeyChanged) || !errors.As(err, &keyErr) || len(keyErr.Want) == 0 {
+		t.Fatalf("Expected a changed host key to be rejected, got %v", err)
+	}
+	empty := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DialSSH(server.addr, SSHOptions{
+		User:       "joe",
+		Password:   "s3cret",
+		KnownHosts: []string{empty},
+		Timeout:    5 * time.Second,
+	}); !errors.Is(err, ErrHostKeyUnknown) {
+		t.Fatalf("Expected an unknown host key to be rejected, got %v", err)
+	}
+}
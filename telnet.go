@@ -0,0 +1,297 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options, from RFC 854 and the RFCs of each option.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptECHO  = 1
+	telnetOptSGA   = 3
+	telnetOptTTYPE = 24
+	telnetOptNAWS  = 31
+
+	telnetTTYPEIs   = 0
+	telnetTTYPESend = 1
+)
+
+// Telnet parser states.
+const (
+	telnetData = iota
+	telnetCR
+	telnetCommand
+	telnetOption
+	telnetSub
+	telnetSubIAC
+)
+
+// TelnetOptions describes the terminal offered to a telnet server by
+// DialTelnet.
+type TelnetOptions struct {
+	// Term is the terminal type sent when the server asks for it (TTYPE).
+	// The default is "xterm".
+	Term string
+	// Rows and Cols give the window size sent when the server asks for it
+	// (NAWS). The default is 24x80.
+	Rows, Cols uint16
+	// Timeout limits connecting, and is the session default timeout.
+	Timeout time.Duration
+}
+
+// telnetConn speaks the telnet protocol over a connection. Reads return the
+// data sent by the server, with the protocol removed and option negotiation
+// answered; writes escape IAC bytes and bare carriage returns.
+type telnetConn struct {
+	conn net.Conn
+	term string
+
+	wmu        sync.Mutex // serializes writes and guards rows and cols
+	rows, cols uint16
+
+	// Used by Read only.
+	state int
+	verb  byte
+	sub   []byte
+
+	mu     sync.Mutex
+	remote map[byte]bool // options the server has enabled
+	local  map[byte]bool // options we have enabled
+}
+
+func (t *telnetConn) Read(p []byte) (int, error) {
+	buf := make([]byte, len(p))
+	for {
+		n, err := t.conn.Read(buf)
+		out := t.parse(p[:0], buf[:n])
+		if len(out) > 0 || err != nil {
+			return len(out), err
+		}
+	}
+}
+
+// parse appends the data in b to out and handles the commands. out never
+// grows longer than b.
+func (t *telnetConn) parse(out, b []byte) []byte {
+	for _, c := range b {
+		switch t.state {
+		case telnetData, telnetCR:
+			cr := t.state == telnetCR
+			t.state = telnetData
+			switch {
+			case c == telnetIAC:
+				t.state = telnetCommand
+			case cr && c == 0:
+				// "\r\0" is a bare carriage return.
+			default:
+				out = append(out, c)
+				if c == '\r' {
+					t.state = telnetCR
+				}
+			}
+		case telnetCommand:
+			switch c {
+			case telnetIAC:
+				out = append(out, telnetIAC)
+				t.state = telnetData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				t.verb = c
+				t.state = telnetOption
+			case telnetSB:
+				t.sub = t.sub[:0]
+				t.state = telnetSub
+			default:
+				// NOP, GA and the like carry nothing for us.
+				t.state = telnetData
+			}
+		case telnetOption:
+			t.negotiate(t.verb, c)
+			t.state = telnetData
+		case telnetSub:
+			if c == telnetIAC {
+				t.state = telnetSubIAC
+			} else {
+				t.sub = append(t.sub, c)
+			}
+		case telnetSubIAC:
+			switch c {
+			case telnetSE:
+				t.subnegotiate(t.sub)
+				t.state = telnetData
+			case telnetIAC:
+				t.sub = append(t.sub, telnetIAC)
+				t.state = telnetSub
+			default:
+				t.state = telnetData
+			}
+		}
+	}
+	return out
+}
+
+// negotiate answers a WILL, WONT, DO or DONT from the server. Only changes of
+// state are answered, so that the two sides cannot loop.
+func (t *telnetConn) negotiate(verb, opt byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch verb {
+	case telnetWILL:
+		if t.remote[opt] {
+			return
+		}
+		if opt == telnetOptECHO || opt == telnetOptSGA {
+			t.remote[opt] = true
+			t.command(telnetDO, opt)
+		} else {
+			t.command(telnetDONT, opt)
+		}
+	case telnetWONT:
+		if t.remote[opt] {
+			t.remote[opt] = false
+			t.command(telnetDONT, opt)
+		}
+	case telnetDO:
+		if t.local[opt] {
+			return
+		}
+		switch opt {
+		case telnetOptSGA, telnetOptTTYPE, telnetOptNAWS:
+			t.local[opt] = true
+			t.command(telnetWILL, opt)
+			if opt == telnetOptNAWS {
+				t.sendWinSize()
+			}
+		default:
+			t.command(telnetWONT, opt)
+		}
+	case telnetDONT:
+		if t.local[opt] {
+			t.local[opt] = false
+			t.command(telnetWONT, opt)
+		}
+	}
+}
+
+func (t *telnetConn) subnegotiate(sub []byte) {
+	if len(sub) == 2 && sub[0] == telnetOptTTYPE && sub[1] == telnetTTYPESend {
+		msg := append([]byte{telnetIAC, telnetSB, telnetOptTTYPE, telnetTTYPEIs}, t.term...)
+		t.write(append(msg, telnetIAC, telnetSE))
+	}
+}
+
+func (t *telnetConn) command(verb, opt byte) {
+	t.write([]byte{telnetIAC, verb, opt})
+}
+
+// sendWinSize sends the window size with NAWS.
+func (t *telnetConn) sendWinSize() error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	msg := []byte{telnetIAC, telnetSB, telnetOptNAWS}
+	for _, v := range []uint16{t.cols, t.rows} {
+		for _, c := range []byte{byte(v >> 8), byte(v)} {
+			msg = append(msg, c)
+			if c == telnetIAC {
+				msg = append(msg, telnetIAC)
+			}
+		}
+	}
+	_, err := t.conn.Write(append(msg, telnetIAC, telnetSE))
+	return err
+}
+
+func (t *telnetConn) write(b []byte) error {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	_, err := t.conn.Write(b)
+	return err
+}
+
+func (t *telnetConn) Write(p []byte) (int, error) {
+	var b bytes.Buffer
+	for i, c := range p {
+		b.WriteByte(c)
+		switch {
+		case c == telnetIAC:
+			b.WriteByte(telnetIAC)
+		case c == '\r' && (i+1 == len(p) || p[i+1] != '\n'):
+			b.WriteByte(0)
+		}
+	}
+	if err := t.write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (t *telnetConn) Close() error {
+	return t.conn.Close()
+}
+
+func (t *telnetConn) setWinSize(rows, cols uint16) error {
+	t.wmu.Lock()
+	t.rows, t.cols = rows, cols
+	t.wmu.Unlock()
+	t.mu.Lock()
+	naws := t.local[telnetOptNAWS]
+	t.mu.Unlock()
+	if !naws {
+		// The size is sent if the server asks for it later.
+		return nil
+	}
+	return t.sendWinSize()
+}
+
+func (t *telnetConn) getWinSize() (rows, cols uint16, err error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	return t.rows, t.cols, nil
+}
+
+// DialTelnet connects to the telnet server at addr, a "host:port", and
+// returns a session reading what the server sends. Option negotiation is
+// handled for the session: the server may echo and suppress go-ahead, and is
+// told the terminal type and window size. SetWinSize sends the new size to
+// the server.
+func DialTelnet(addr string, opts TelnetOptions) (*ExpectSubprocess, error) {
+	conn, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewTelnetSession(conn, opts), nil
+}
+
+// NewTelnetSession is DialTelnet over an existing connection.
+func NewTelnetSession(conn net.Conn, opts TelnetOptions) *ExpectSubprocess {
+	if opts.Term == "" {
+		opts.Term = "xterm"
+	}
+	if opts.Rows == 0 || opts.Cols == 0 {
+		opts.Rows, opts.Cols = 24, 80
+	}
+	t := &telnetConn{
+		conn:   conn,
+		term:   opts.Term,
+		rows:   opts.Rows,
+		cols:   opts.Cols,
+		remote: map[byte]bool{},
+		local:  map[byte]bool{},
+	}
+	expect := NewSession(t, WithTimeout(opts.Timeout))
+	expect.termName = opts.Term
+	// Negotiation is answered as the output is read, so start reading now.
+	expect.buf.run()
+	return expect
+}
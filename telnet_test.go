@@ -0,0 +1,131 @@
+// +build !windows
+
+package gexpect
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// readExactly reads len(want) bytes from conn and reports whether they are
+// want.
+func readExactly(t *testing.T, conn net.Conn, want []byte) {
+	got := make([]byte, len(want))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.ReadFull(conn, got); err != nil {
+		t.Errorf("Expected %q from the client: %v", want, err)
+		return
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Expected %q from the client, got %q", want, got)
+	}
+}
+
+func TestTelnet(t *testing.T) {
+	t.Logf("Testing DialTelnet...")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte{
+			telnetIAC, telnetDO, telnetOptTTYPE,
+			telnetIAC, telnetDO, telnetOptNAWS,
+			telnetIAC, telnetWILL, telnetOptECHO,
+			telnetIAC, telnetWILL, telnetOptSGA,
+			telnetIAC, telnetDO, 99,
+			telnetIAC, telnetSB, telnetOptTTYPE, telnetTTYPESend, telnetIAC, telnetSE,
+		})
+		readExactly(t, conn, []byte{
+			telnetIAC, telnetWILL, telnetOptTTYPE,
+			telnetIAC, telnetWILL, telnetOptNAWS,
+			telnetIAC, telnetSB, telnetOptNAWS, 0, 132, 0, 50, telnetIAC, telnetSE,
+			telnetIAC, telnetDO, telnetOptECHO,
+			telnetIAC, telnetDO, telnetOptSGA,
+			telnetIAC, telnetWONT, 99,
+			telnetIAC, telnetSB, telnetOptTTYPE, telnetTTYPEIs, 'v', 't', '1', '0', '0', telnetIAC, telnetSE,
+		})
+		conn.Write([]byte("login\xff\xff: "))
+		readExactly(t, conn, []byte("r\xff\xff\r\x00t\r\n"))
+		readExactly(t, conn, []byte{telnetIAC, telnetSB, telnetOptNAWS, 0, 100, 0, 40, telnetIAC, telnetSE})
+		conn.Write([]byte("welcome\r\n"))
+	}()
+
+	session, err := DialTelnet(l.Addr().String(), TelnetOptions{Term: "vt100", Rows: 50, Cols: 132, Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err = session.Expect("login\xff: "); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.SendLine("r\xff\rt"); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.SetWinSize(40, 100); err != nil {
+		t.Fatal(err)
+	}
+	if rows, cols, err := session.GetWinSize(); err != nil || rows != 40 || cols != 100 {
+		t.Fatalf("Expected a size of 40x100, got %dx%d, %v", rows, cols, err)
+	}
+	if err = session.Expect("welcome"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}
+
+func TestTelnetSendDelay(t *testing.T) {
+	t.Logf("Testing DialTelnet with a send delay...")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("login: "))
+		// The line ending sent with a delay is still a CR LF, and a bare
+		// \r gets its NUL at once.
+		readExactly(t, conn, []byte("hi\r\nx\r\x00"))
+		conn.Write([]byte("welcome\r\n"))
+	}()
+
+	session, err := DialTelnet(l.Addr().String(), TelnetOptions{Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	if err = session.Expect("login: "); err != nil {
+		t.Fatal(err)
+	}
+	session.SetSendDelay(time.Millisecond, 0)
+	if err = session.SendLine("hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Send("x\r"); err != nil {
+		t.Fatal(err)
+	}
+	if err = session.Expect("welcome"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+}